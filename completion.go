@@ -0,0 +1,89 @@
+// Copyright (C) 2014-2017 Miquel Sabaté Solà <mikisabate@gmail.com>
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"strings"
+
+	"github.com/codegangsta/cli"
+	"github.com/mssola/td/lib"
+)
+
+var (
+	// The shells supported by the `completion` command.
+	shells = []string{"bash", "zsh", "fish"}
+
+	// The commands whose arguments are names of existing topics.
+	topicCommands = map[string]bool{"edit": true, "delete": true, "rename": true, "show": true}
+
+	// The commands whose first argument is the key of a setting.
+	settingCommands = map[string]bool{"config get": true, "config set": true, "config unset": true}
+)
+
+// completedFlags returns the description of the given flags for the
+// completion scripts.
+func completedFlags(flags []cli.Flag) []lib.CompletedFlag {
+	var res []lib.CompletedFlag
+
+	for _, f := range flags {
+		cf := lib.CompletedFlag{Names: strings.Split(f.GetName(), ", ")}
+		switch flag := f.(type) {
+		case cli.BoolFlag:
+			cf.Usage = flag.Usage
+		case cli.BoolTFlag:
+			cf.Usage = flag.Usage
+		case cli.StringFlag:
+			cf.Usage, cf.Value = flag.Usage, true
+		default:
+			cf.Value = true
+		}
+		res = append(res, cf)
+	}
+	return res
+}
+
+// completedCommands returns the description of the commands of the given
+// application for the completion scripts.
+func completedCommands(app *cli.App) []lib.CompletedCommand {
+	return completedList(app.Commands, "")
+}
+
+// completedList returns the description of the given commands, which are
+// subcommands of the given parent command if it's not empty.
+func completedList(list []cli.Command, parent string) []lib.CompletedCommand {
+	var names []string
+	var commands []lib.CompletedCommand
+
+	for _, c := range list {
+		names = append(names, c.Name)
+	}
+	for _, c := range list {
+		full := strings.TrimSpace(parent + " " + c.Name)
+		cc := lib.CompletedCommand{
+			Name:        c.Name,
+			Usage:       c.Usage,
+			Flags:       completedFlags(c.Flags),
+			Topics:      topicCommands[full],
+			Subcommands: completedList(c.Subcommands, full),
+		}
+		switch {
+		case cc.Topics, c.Name == "create", len(cc.Subcommands) > 0:
+			// Nothing to complete for the name of a new topic, and
+			// subcommands are completed instead of arguments.
+		case settingCommands[full]:
+			cc.Values = lib.SettingKeys()
+		case c.Name == "completion":
+			cc.Values = shells
+		case c.Name == "help":
+			cc.Values = names
+		default:
+			cc.Files = strings.TrimSpace(c.ArgsUsage) != ""
+		}
+		commands = append(commands, cc)
+	}
+	return commands
+}
@@ -0,0 +1,187 @@
+// Copyright (C) 2014-2017 Miquel Sabaté Solà <mikisabate@gmail.com>
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package lib
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// entry is a file inside of an archive or an exported directory. Paths are
+// always slash-separated.
+type entry struct {
+	path string
+	data []byte
+}
+
+// compressed returns true if the archive with the given path has to be
+// compressed with gzip.
+func compressed(path string) bool {
+	return strings.HasSuffix(path, ".gz") || strings.HasSuffix(path, ".tgz")
+}
+
+// topicEntries returns the entries for the given topics: a "topics.json" file
+// with the list of topics (as in the cache), and the file of each topic.
+func topicEntries(topics []Topic) []entry {
+	var list []Topic
+	var entries []entry
+
+	for _, t := range topics {
+		list = append(list, Topic{ID: t.ID, Name: t.Name, CreatedAt: t.CreatedAt})
+		path := filepath.ToSlash(topicFile(t.Name))
+		entries = append(entries, entry{path: path, data: []byte(t.Contents)})
+	}
+	body, _ := json.MarshalIndent(list, "", "  ")
+	return append([]entry{{path: topicsName, data: body}}, entries...)
+}
+
+// entriesTopics returns the topics contained in the given entries, which are
+// indexed by their path. Topics listed in the "topics.json" file keep their
+// information, and the files of topics that are not listed there are also
+// taken into account (e.g. directories written by hand).
+func entriesTopics(entries map[string][]byte) ([]Topic, error) {
+	var topics []Topic
+
+	if body, ok := entries[topicsName]; ok {
+		if err := json.Unmarshal(body, &topics); err != nil {
+			return nil, err
+		}
+	}
+
+	listed := make(map[string]bool)
+	for k, t := range topics {
+		path := filepath.ToSlash(topicFile(t.Name))
+		topics[k].Contents = string(entries[path])
+		listed[path] = true
+	}
+
+	var paths []string
+	for path := range entries {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		if listed[path] || ignored(filepath.Base(path)) {
+			continue
+		}
+		if name, ok := topicName(path); ok {
+			topics = append(topics, Topic{Name: name, Contents: string(entries[path])})
+		}
+	}
+	return topics, nil
+}
+
+// writeEntries writes the given entries inside of the given directory.
+func writeEntries(dir string, entries []entry) error {
+	for _, e := range entries {
+		path := filepath.Join(dir, filepath.FromSlash(e.path))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return err
+		}
+		if err := writeFile(path, e.data, 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readEntries returns the contents of the files inside of the given directory
+// indexed by their path relative to it.
+func readEntries(dir string) (map[string][]byte, error) {
+	entries := make(map[string][]byte)
+
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			if path != dir && ignored(info.Name()) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		data, err := ioutil.ReadFile(path)
+		entries[filepath.ToSlash(rel)] = data
+		return err
+	})
+	return entries, err
+}
+
+// writeTar writes the given entries as a tar archive into the given writer. If
+// gz is true, then the archive is compressed with gzip.
+func writeTar(w io.Writer, entries []entry, gz bool) error {
+	if gz {
+		zw := gzip.NewWriter(w)
+		if err := writeTar(zw, entries, false); err != nil {
+			return err
+		}
+		return zw.Close()
+	}
+
+	tw := tar.NewWriter(w)
+	now := time.Now()
+	for _, e := range entries {
+		hdr := &tar.Header{
+			Name:    e.path,
+			Mode:    0644,
+			Size:    int64(len(e.data)),
+			ModTime: now,
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if _, err := tw.Write(e.data); err != nil {
+			return err
+		}
+	}
+	return tw.Close()
+}
+
+// readTar returns the contents of the files inside of the tar archive from
+// the given reader indexed by their path. If gz is true, then the archive is
+// decompressed with gzip first.
+func readTar(r io.Reader, gz bool) (map[string][]byte, error) {
+	if gz {
+		zr, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		defer func() { _ = zr.Close() }()
+		r = zr
+	}
+
+	entries := make(map[string][]byte)
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return entries, nil
+		} else if err != nil {
+			return nil, err
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		data, err := ioutil.ReadAll(tr)
+		if err != nil {
+			return nil, err
+		}
+		entries[strings.TrimPrefix(hdr.Name, "./")] = data
+	}
+}
@@ -0,0 +1,66 @@
+// Copyright (C) 2014-2017 Miquel Sabaté Solà <mikisabate@gmail.com>
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package lib
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func compareTopics(t *testing.T, given, expected []Topic) {
+	if len(given) != len(expected) {
+		t.Fatalf("Expected %v topics, got %v: %v", len(expected), len(given), given)
+	}
+	for k, v := range given {
+		e := expected[k]
+		if v.ID != e.ID || v.Name != e.Name || v.Contents != e.Contents || !v.CreatedAt.Equal(e.CreatedAt) {
+			t.Fatalf("Expected %+v, got %+v", e, v)
+		}
+	}
+}
+
+var archiveTopics = []Topic{
+	{ID: "1", Name: "work/backend", Contents: "backend"},
+	{ID: "2", Name: "100%", Contents: ""},
+}
+
+func TestTarEntries(t *testing.T) {
+	for _, gz := range []bool{false, true} {
+		var b bytes.Buffer
+		errCheck(t, writeTar(&b, topicEntries(archiveTopics), gz))
+
+		entries, err := readTar(&b, gz)
+		errCheck(t, err)
+		if string(entries["work/backend.md"]) != "backend" {
+			t.Fatalf("Unexpected entries: %v", entries)
+		}
+		topics, err := entriesTopics(entries)
+		errCheck(t, err)
+		compareTopics(t, topics, archiveTopics)
+	}
+}
+
+func TestDirEntries(t *testing.T) {
+	dir, err := ioutil.TempDir("", "td-entries")
+	errCheck(t, err)
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	errCheck(t, writeEntries(dir, topicEntries(archiveTopics)))
+
+	// Files written by hand are picked too, but not ignored ones.
+	errCheck(t, ioutil.WriteFile(filepath.Join(dir, "new.md"), []byte("new"), 0644))
+	errCheck(t, ioutil.WriteFile(filepath.Join(dir, "new.md~"), []byte("backup"), 0644))
+
+	entries, err := readEntries(dir)
+	errCheck(t, err)
+	topics, err := entriesTopics(entries)
+	errCheck(t, err)
+	compareTopics(t, topics, append(archiveTopics, Topic{Name: "new", Contents: "new"}))
+}
@@ -0,0 +1,327 @@
+// Copyright (C) 2014-2017 Miquel Sabaté Solà <mikisabate@gmail.com>
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package lib
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// The version of the format of backups. Backups with a greater version
+	// cannot be restored.
+	backupVersion = 1
+
+	// The name of the file describing the contents of a backup.
+	manifestName = "manifest.json"
+
+	// The directory inside of a backup containing the topics as they were
+	// fetched from the server.
+	serverDir = "server"
+)
+
+// manifest describes the contents of a backup.
+type manifest struct {
+	Version   int       `json:"version"`
+	CreatedAt time.Time `json:"created_at"`
+	Server    string    `json:"server"`
+
+	// Whether the configuration contains the tokens or not.
+	Token bool `json:"token"`
+
+	// The SHA-256 checksum of each file, indexed by its path.
+	Files map[string]string `json:"files"`
+}
+
+// checksum returns the hexadecimal SHA-256 checksum of the given data.
+func checksum(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// prefixed returns the given entries with their paths inside of the given
+// directory.
+func prefixed(dir string, entries []entry) []entry {
+	for k := range entries {
+		entries[k].path = path.Join(dir, entries[k].path)
+	}
+	return entries
+}
+
+// Backup writes into the given path an archive with the local state (the
+// configuration, the list of topics and the cached topics) and the topics as
+// they are on the server right now. If withoutToken is true, then the tokens
+// are removed from the configuration being saved. The archive is compressed if
+// the path ends with ".gz" or ".tgz". A manifest with the checksum of each
+// file is included, so the archive can be validated before being restored.
+func Backup(dst string, withoutToken bool) error {
+	unlock, err := lockCache()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	topics, err := serverTopics()
+	if err != nil {
+		return err
+	}
+
+	// The configuration, without the tokens if requested.
+	cfg := *config.saved()
+	if withoutToken {
+		cfg.Token = ""
+		cfg.Profiles = make(map[string]*profile)
+		for name, p := range config.Profiles {
+			cfg.Profiles[name] = &profile{Server: p.Server}
+		}
+	}
+	body, _ := json.Marshal(&cfg)
+	list, _ := ioutil.ReadFile(dataPath(topicsName))
+	entries := []entry{{path: configName, data: body}, {path: topicsName, data: list}}
+
+	for _, d := range []string{oldDir, newDir} {
+		files, err := readEntries(dataPath(d))
+		if err != nil && !os.IsNotExist(err) {
+			return fromError(err)
+		}
+		var paths []string
+		for p := range files {
+			paths = append(paths, p)
+		}
+		sort.Strings(paths)
+		for _, p := range paths {
+			entries = append(entries, entry{path: path.Join(d, p), data: files[p]})
+		}
+	}
+	entries = append(entries, prefixed(serverDir, topicEntries(topics))...)
+
+	m := &manifest{
+		Version:   backupVersion,
+		CreatedAt: time.Now().UTC(),
+		Server:    config.Server,
+		Token:     !withoutToken,
+		Files:     make(map[string]string),
+	}
+	for _, e := range entries {
+		m.Files[e.path] = checksum(e.data)
+	}
+	body, _ = json.MarshalIndent(m, "", "  ")
+	entries = append([]entry{{path: manifestName, data: body}}, entries...)
+
+	var buffer bytes.Buffer
+	if err := writeTar(&buffer, entries, compressed(dst)); err != nil {
+		return fromError(err)
+	}
+	if err := writeFile(dst, buffer.Bytes(), 0600); err != nil {
+		return fromError(err)
+	}
+	fmt.Printf("Saved %v topics into '%v'.\n", len(topics), dst)
+	return nil
+}
+
+// readBackup reads the backup from the given path and validates it against
+// its manifest. It returns the manifest and the files of the backup indexed
+// by their path.
+func readBackup(src string) (*manifest, map[string][]byte, error) {
+	body, err := readInput(src)
+	if err != nil {
+		return nil, nil, fromError(err)
+	}
+	gz := bytes.HasPrefix(body, []byte{0x1f, 0x8b})
+	files, err := readTar(bytes.NewReader(body), gz)
+	if err != nil {
+		return nil, nil, NewError(fmt.Sprintf("'%v' is not a valid backup: %v", src, err))
+	}
+
+	invalid := func(reason string) error {
+		return NewError(fmt.Sprintf("'%v' is not a valid backup: %v", src, reason))
+	}
+
+	var m manifest
+	if err := json.Unmarshal(files[manifestName], &m); err != nil {
+		return nil, nil, invalid("the manifest is missing or malformed")
+	}
+	if m.Version < 1 || m.Version > backupVersion {
+		return nil, nil, invalid(fmt.Sprintf("unsupported version %v", m.Version))
+	}
+	delete(files, manifestName)
+
+	for p, sum := range m.Files {
+		data, ok := files[p]
+		if !ok {
+			return nil, nil, invalid(fmt.Sprintf("'%v' is missing", p))
+		}
+		if checksum(data) != sum {
+			return nil, nil, invalid(fmt.Sprintf("wrong checksum for '%v'", p))
+		}
+	}
+	for p := range files {
+		if _, ok := m.Files[p]; !ok {
+			return nil, nil, invalid(fmt.Sprintf("unexpected file '%v'", p))
+		}
+		if path.IsAbs(p) || p != path.Clean(p) || p == ".." || strings.HasPrefix(p, "../") {
+			return nil, nil, invalid(fmt.Sprintf("unsafe path '%v'", p))
+		}
+	}
+	for _, p := range []string{configName, topicsName} {
+		if _, ok := files[p]; !ok {
+			return nil, nil, invalid(fmt.Sprintf("'%v' is missing", p))
+		}
+	}
+	return &m, files, nil
+}
+
+// Restore rebuilds the local state from the backup with the given path, after
+// validating it (see Backup). If there are local changes that have not been
+// pushed, nothing is done unless force is true. If the backup does not
+// contain the token, then the current token is kept as long as it belongs to
+// the same server. If push is true, then the topics from the server saved in
+// the backup are pushed back to the server.
+func Restore(src string, push, force bool) error {
+	m, files, err := readBackup(src)
+	if err != nil {
+		return err
+	}
+
+	root := dataPath()
+	if err := os.MkdirAll(root, 0755); err != nil {
+		return fromError(err)
+	}
+	unlock, err := lockCache()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if !force && (len(changedTopics()) > 0 || len(createdTopics()) > 0) {
+		return See("you have changes that have not been pushed", "restore --force")
+	}
+
+	// The configuration.
+	var cfg configuration
+	if err := json.Unmarshal(files[configName], &cfg); err != nil {
+		return NewError("could not read the configuration from the backup")
+	}
+	if !m.Token && config.Token != "" && strings.TrimRight(config.Server, "/") == strings.TrimRight(cfg.Server, "/") {
+		cfg.Token = config.Token
+	}
+
+	// The cached topics.
+	for _, d := range []string{oldDir, newDir} {
+		tmp, err := ioutil.TempDir(root, "."+d+".")
+		if err != nil {
+			return fromError(err)
+		}
+		defer func() { _ = os.RemoveAll(tmp) }()
+		_ = os.Chmod(tmp, 0755)
+
+		if err := writeEntries(tmp, entriesIn(files, d)); err != nil {
+			return fromError(err)
+		}
+		if err := swapDir(tmp, filepath.Join(root, d)); err != nil {
+			return fromError(err)
+		}
+	}
+	if err := writeFile(filepath.Join(root, topicsName), files[topicsName], 0644); err != nil {
+		return fromError(err)
+	}
+	*config = cfg
+	config.logged = cfg.Token != ""
+	if err := saveConfig(); err != nil {
+		return fromError(err)
+	}
+	fmt.Printf("Restored the backup from %v.\n", m.CreatedAt.Local().Format(time.RFC1123))
+
+	if !config.logged {
+		warning("the backup does not contain a token, so you have to log in again.", "")
+		return nil
+	}
+	if push {
+		return pushBackup(files)
+	}
+	return nil
+}
+
+// entriesIn returns the entries from the given files which are inside of the
+// given directory, with their paths relative to it.
+func entriesIn(files map[string][]byte, dir string) []entry {
+	var entries []entry
+
+	for p, data := range files {
+		if strings.HasPrefix(p, dir+"/") {
+			entries = append(entries, entry{path: strings.TrimPrefix(p, dir+"/"), data: data})
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].path < entries[j].path })
+	return entries
+}
+
+// pushBackup pushes the topics from the server saved in the given files of a
+// backup. Topics are matched by ID and then by name with the ones on the
+// server, and the missing ones are created.
+func pushBackup(files map[string][]byte) error {
+	saved := make(map[string][]byte)
+	for _, e := range entriesIn(files, serverDir) {
+		saved[e.path] = e.data
+	}
+	topics, err := entriesTopics(saved)
+	if err != nil {
+		return fromError(err)
+	}
+	existing, err := serverTopics()
+	if err != nil {
+		return err
+	}
+
+	var fails []string
+	for k, t := range topics {
+		fmt.Printf("\rPushing... %v/%v\r", k+1, len(topics))
+
+		id := ""
+		for _, e := range existing {
+			if e.ID == t.ID && t.ID != "" {
+				id = e.ID
+				break
+			} else if e.Name == t.Name {
+				id = e.ID
+			}
+		}
+		if id == "" {
+			nt, err := createTopic(t.Name)
+			if err != nil {
+				fails = append(fails, t.Name)
+				continue
+			}
+			id = nt.ID
+		}
+		if err := pushContents(id, t.Contents); err != nil {
+			fails = append(fails, t.Name)
+		}
+	}
+	fmt.Println()
+
+	// The IDs of the created topics are new, so refresh the cache if we can.
+	if safeFetch() {
+		if err := fetch(); err != nil {
+			warning("could not fetch the topics: %v.", errorMessage(err))
+		}
+	}
+	if len(fails) > 0 {
+		return NewError("could not push: " + strings.Join(fails, ", "))
+	}
+	return nil
+}
@@ -0,0 +1,150 @@
+// Copyright (C) 2014-2017 Miquel Sabaté Solà <mikisabate@gmail.com>
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package lib
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mssola/capture"
+)
+
+// backupServer prepares a logged in session with the cached topics.
+func backupServer(t *testing.T) (string, func()) {
+	startTestEnv(t)
+	ts := topicServer(nil)
+	config = &configuration{Server: ts.URL, Token: "1234"}
+	errCheck(t, saveConfig())
+	capture.All(func() { errCheck(t, fetch()) })
+
+	dir, err := ioutil.TempDir("", "td-backup")
+	errCheck(t, err)
+	return dir, func() {
+		ts.Close()
+		_ = os.RemoveAll(dir)
+		stopTestEnv(t)
+	}
+}
+
+func TestBackupRestore(t *testing.T) {
+	dir, cleanup := backupServer(t)
+	defer cleanup()
+
+	file := filepath.Join(dir, "backup.tar.gz")
+	var err error
+	capture.All(func() { err = Backup(file, false) })
+	if err != nil {
+		t.Fatalf("Not expecting error: %v", err)
+	}
+
+	m, files, err := readBackup(file)
+	if err != nil {
+		t.Fatalf("Not expecting error: %v", err)
+	}
+	if m.Version != backupVersion || !m.Token || m.Server != config.Server {
+		t.Fatalf("Unexpected manifest: %+v", m)
+	}
+	for _, p := range []string{"config.json", "topics.json", "old/topic1.md", "new/topic2.md", "server/topic1.md"} {
+		if _, ok := files[p]; !ok {
+			t.Fatalf("Expecting '%v' in the backup: %v", p, files)
+		}
+	}
+
+	// Mess up the local state and the server, and then restore it.
+	writeIn(t, filepath.Join(home(), dirName, newDir), "topic1.md", "changed")
+	capture.All(func() { err = Restore(file, false, false) })
+	if err == nil || !strings.Contains(err.Error(), "you have changes that have not been pushed") {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	testTopics[0].Contents = "lost"
+	config.Token = ""
+	capture.All(func() { err = Restore(file, true, true) })
+	if err != nil {
+		t.Fatalf("Not expecting error: %v", err)
+	}
+	if c := readNew(t, "topic1.md"); c != "1111" {
+		t.Fatalf("Expecting '1111', got: %v", c)
+	}
+	if testTopics[0].Contents != "1111" || config.Token != "1234" || !LoggedIn() {
+		t.Fatalf("Unexpected state: %v %+v", testTopics, config)
+	}
+}
+
+func TestBackupWithoutToken(t *testing.T) {
+	dir, cleanup := backupServer(t)
+	defer cleanup()
+
+	file := filepath.Join(dir, "backup.tar")
+	config.Profiles = map[string]*profile{"other": {Server: "https://other.org", Token: "5678"}}
+	var err error
+	capture.All(func() { err = Backup(file, true) })
+	if err != nil {
+		t.Fatalf("Not expecting error: %v", err)
+	}
+	_, files, err := readBackup(file)
+	errCheck(t, err)
+	var cfg configuration
+	errCheck(t, json.Unmarshal(files["config.json"], &cfg))
+	if cfg.Token != "" || cfg.Profiles["other"].Token != "" || cfg.Profiles["other"].Server != "https://other.org" {
+		t.Fatalf("Unexpected configuration: %s", files["config.json"])
+	}
+
+	// The current token is kept on the same server.
+	config.Token = "4321"
+	capture.All(func() { err = Restore(file, false, false) })
+	if err != nil || config.Token != "4321" {
+		t.Fatalf("Unexpected restore: %v %+v", err, config)
+	}
+
+	// But not on another one.
+	config.Server = "https://other.org"
+	res := capture.All(func() { err = Restore(file, false, false) })
+	if err != nil || LoggedIn() {
+		t.Fatalf("Unexpected restore: %v %+v", err, config)
+	}
+	if !strings.Contains(string(res.Stdout), "you have to log in again") {
+		t.Fatalf("Expecting a warning, got: %s", res.Stdout)
+	}
+}
+
+func TestInvalidBackup(t *testing.T) {
+	dir, cleanup := backupServer(t)
+	defer cleanup()
+
+	tests := []struct {
+		entries []entry
+		msg     string
+	}{
+		{[]entry{{path: "config.json"}}, "the manifest is missing or malformed"},
+		{[]entry{{path: manifestName, data: []byte(`{"version": 2}`)}}, "unsupported version 2"},
+		{[]entry{{path: manifestName, data: []byte(`{"version": 1, "files": {"a": "b"}}`)}}, "'a' is missing"},
+		{[]entry{{path: manifestName, data: []byte(`{"version": 1, "files": {"a": "b"}}`)}, {path: "a"}}, "wrong checksum for 'a'"},
+		{[]entry{{path: manifestName, data: []byte(`{"version": 1}`)}, {path: "a"}}, "unexpected file 'a'"},
+		{[]entry{{path: manifestName, data: []byte(`{"version": 1}`)}}, "'config.json' is missing"},
+		{[]entry{
+			{path: manifestName, data: []byte(`{"version": 1, "files": {"../a": "` + checksum(nil) + `"}}`)},
+			{path: "../a"},
+		}, "unsafe path '../a'"},
+	}
+
+	file := filepath.Join(dir, "backup.tar")
+	for _, test := range tests {
+		var b bytes.Buffer
+		errCheck(t, writeTar(&b, test.entries, false))
+		errCheck(t, ioutil.WriteFile(file, b.Bytes(), 0644))
+
+		err := Restore(file, false, false)
+		if err == nil || !strings.Contains(err.Error(), test.msg) {
+			t.Fatalf("Expecting '%v', got: %v", test.msg, err)
+		}
+	}
+}
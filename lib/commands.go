@@ -7,25 +7,21 @@
 package lib
 
 import (
-	"bytes"
-	"encoding/json"
 	"fmt"
+	"io/ioutil"
 	"os"
 	"os/exec"
-	"path/filepath"
+	"strings"
 )
 
-// Done this way to test it.
-var editCommand = func() error {
-	var cmd *exec.Cmd
+// Done this way to test it. It opens the editor inside of the given directory.
+// The given file arguments (see fileArguments) are passed to the editor.
+var editCommand = func(dir string, files []string) error {
+	ed := editor()
+	args := append(ed[1:], editorArguments()...)
+	cmd := exec.Command(ed[0], append(args, files...)...)
 
-	if args := editorArguments(); len(args) == 0 {
-		cmd = exec.Command(editor())
-	} else {
-		cmd = exec.Command(editor(), args...)
-	}
-
-	cmd.Dir = filepath.Join(home(), dirName, newDir)
+	cmd.Dir = dir
 	cmd.Stdin = os.Stdin
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
@@ -33,125 +29,262 @@ var editCommand = func() error {
 	return cmd.Run()
 }
 
+// prepareEdit fetches the topics and creates the workspace for a new editor
+// session while holding the lock of the cache. It also returns the names of
+// the topics referred by the given arguments (see resolveTopics), or nil if
+// no arguments were given.
+func prepareEdit(args []string) (*workspace, []string, error) {
+	var only []string
+	var lines map[string]int
+
+	unlock, err := lockCache()
+	if err != nil {
+		return nil, nil, err
+	}
+	defer unlock()
+
+	// Topics left with conflicts by a previous session have to be fixed
+	// before anything can be fetched again, so they are edited from the cache.
+	changed := changedTopics()
+	if len(changed) > 0 && len(conflictedTopics(changed)) == len(changed) && len(createdTopics()) == 0 {
+		progress("Some topics still have conflicts, editing them without fetching the topics first.\n")
+	} else if err := fetch(); err != nil {
+		return nil, nil, fromError(err)
+	}
+	if len(args) > 0 {
+		if only, lines, err = resolveTopics(args); err != nil {
+			return nil, nil, err
+		}
+	}
+	ws, err := newWorkspace(only)
+	if err != nil {
+		return nil, nil, fromError(err)
+	}
+	for name, line := range lines {
+		ws.lines[topicFile(name)] = line
+	}
+	return ws, only, nil
+}
+
 // Edit performs the default command. That is, it fetches all the topics, opens
-// up the default editor and pushes the changes.
-func Edit() error {
+// up the default editor and pushes the changes. The editor is opened inside of
+// a workspace of its own, so other td processes can run while the user is
+// editing. When the editor exits, the workspace is merged back. If some
+// arguments are given, then the editor only opens the topics referred by them
+// (see resolveTopics), and only these topics are pushed afterwards. Arguments
+// can also be suffixed with a line number (e.g. "topic:42"), so the editor
+// places the cursor there. Topics that could not be merged cleanly keep their
+// conflict markers, and they are not pushed until they get fixed.
+func Edit(args ...string) error {
 	// Fetch the topics from the server.
-	if err := fetch(); err != nil {
-		return fromError(err)
+	ws, only, err := prepareEdit(args)
+	if err != nil {
+		return err
 	}
+	defer ws.remove()
 
 	// Open up the editor.
-	if err := editCommand(); err != nil {
+	editErr := editCommand(ws.work(), fileArguments(ws.files, ws.lines))
+
+	// Bring back the changes from this session.
+	unlock, err := lockCache()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	files, err := ws.merge()
+	if err != nil {
 		return fromError(err)
 	}
+	var conflicts []string
+	for _, file := range files {
+		if name, ok := topicName(file); ok {
+			conflicts = append(conflicts, name)
+		}
+	}
+	if len(conflicts) > 0 {
+		warning("the following topics were also changed by another session "+
+			"and they could not be merged cleanly:%v", "\n\t"+strings.Join(conflicts, "\n\t"))
+		fmt.Printf("Both versions have been kept in them and they have not been pushed, " +
+			"fix them on the next edit.\n")
+	}
 
-	// Push all the changed files.
-	changed := changedTopics()
-	if len(changed) > 0 {
-		fmt.Printf("Pushing your changes to the server.\n")
-		pushTopics(changed)
+	// Conflicts from previous sessions that have not been fixed yet.
+	var pending []string
+	for _, name := range conflictedTopics(changedTopics()) {
+		if !contains(conflicts, name) {
+			pending = append(pending, name)
+		}
 	}
-	return nil
+	if len(pending) > 0 {
+		warning("the following topics still have conflict markers and they "+
+			"have not been pushed:%v", "\n\t"+strings.Join(pending, "\n\t"))
+	}
+	if editErr != nil {
+		return fromError(editErr)
+	}
+
+	// Push all the changes, except for the topics with conflicts.
+	return syncTopics(only, append(conflicts, pending...))
 }
 
-// List simply shows the currently available topics.
-func List() error {
+// List simply shows the currently available topics. Namespaced topics are
+// shown as a tree unless another output is requested through the given
+// options (see ListOptions). Topics are fetched first, unless the cache is
+// still fresh (see the "cache_ttl" setting) or the Offline option is given.
+func List(opts *ListOptions) error {
+	if !opts.Flat && !opts.JSON && !opts.Long && opts.Format == "" {
+		opts.useDefault(settingValue("list_format"))
+	}
+	if opts.Offline && opts.names() {
+		listNames()
+		return nil
+	}
+
 	// Try to fetch them if no one else has done it. We can safely ignore the
 	// error since we can still cache it if it exists. Otherwise it's not such
-	// a pain to get an empty list on weird scenarios.
-	_ = fetch()
+	// a pain to get an empty list on weird scenarios. For the same reason, if
+	// another process is holding the lock, we just show the cached list. Note
+	// that the output might be parsed by scripts, so messages don't go into
+	// the standard output in this case.
+	if opts.JSON || opts.Format != "" {
+		dataOnStdout = true
+		defer func() { dataOnStdout = false }()
+	}
+	if !opts.Offline && !freshCache() {
+		if unlock, err := lockCache(); err == nil {
+			_ = fetch()
+			unlock()
+		}
+	}
 
-	var topics []Topic
-	readTopics(&topics)
-	for _, v := range topics {
-		fmt.Printf("%v\n", v.Name)
+	topics, err := listTopics(opts.Sort)
+	if err != nil {
+		return err
 	}
-	return nil
+	return printTopics(topics, opts)
 }
 
-// Create creates a new topic on the server.
-func Create(name string) error {
-	// Perform the HTTP request.
-	t := &Topic{Name: name}
-	body, _ := json.Marshal(t)
-	res, err := getResponse("POST", "/topics", bytes.NewReader(body))
+// Show renders the contents of the given topics on the terminal. Topics are
+// referred as in the `edit` command (see resolveTopics). Long outputs are
+// shown through a pager, and the plain text is shown when the standard output
+// is not a terminal. Progress messages never get mixed with the topics, so the
+// output can be piped into other programs.
+func Show(args ...string) error {
+	dataOnStdout = true
+	defer func() { dataOnStdout = false }()
+
+	if unlock, err := lockCache(); err == nil {
+		_ = fetch()
+		unlock()
+	}
+
+	names, _, err := resolveTopics(args)
 	if err != nil {
 		return err
 	}
 
-	// Parse the newly created topic and add it to the list.
-	if err = topicResponse(t, res); err != nil {
-		return NewError("could not create this topic: " + err.Error())
+	var out []string
+	dir := dataPath(newDir)
+	for _, name := range names {
+		contents, err := ioutil.ReadFile(topicPath(dir, name))
+		if err != nil {
+			return fromError(err)
+		}
+		out = append(out, renderMarkdown(string(contents), colorEnabled(isTerminal())))
+	}
+
+	text := strings.Join(out, "\n")
+	if isTerminal() {
+		page(text)
+	} else {
+		fmt.Print(text)
 	}
-	addTopic(t)
 	return nil
 }
 
-// Delete deletes the specified topic from the server.
-func Delete(name string) error {
-	var topics, actual []Topic
-	var id string
-
-	// Get the list of topics straight.
-	readTopics(&topics)
-	for _, v := range topics {
-		if v.Name == name {
-			id = v.ID
+// tree represents the namespaces of the topics. Children are kept in the
+// order in which they were inserted.
+type tree struct {
+	name     string
+	topic    bool
+	children []*tree
+}
+
+// newTree builds a tree from the given topic names.
+func newTree(names []string) *tree {
+	root := &tree{}
+
+	for _, name := range names {
+		node := root
+		if namespaced(name) {
+			segments := strings.Split(name, "/")
+			for _, s := range segments[:len(segments)-1] {
+				node = node.child(s, false)
+			}
+			name = segments[len(segments)-1]
+		}
+		node.child(name, true)
+	}
+	return root
+}
+
+// child returns the child with the given name, creating it if needed. Topics
+// and namespaces with the same name are different children.
+func (t *tree) child(name string, topic bool) *tree {
+	for _, c := range t.children {
+		if c.name == name && c.topic == topic {
+			return c
+		}
+	}
+	c := &tree{name: name, topic: topic}
+	t.children = append(t.children, c)
+	return c
+}
+
+// printTree prints the given tree with the given indentation.
+func printTree(t *tree, indent string) {
+	for _, c := range t.children {
+		if c.topic {
+			fmt.Printf("%v%v\n", indent, c.name)
 		} else {
-			actual = append(actual, v)
+			fmt.Printf("%v%v/\n", indent, c.name)
+			printTree(c, indent+"  ")
 		}
 	}
-	if id == "" {
-		return unknownTopic(name)
+}
+
+// Create creates a new topic on the server.
+func Create(name string) error {
+	unlock, err := lockCache()
+	if err != nil {
+		return err
 	}
+	defer unlock()
+
+	_, err = newTopic(name, "")
+	return err
+}
 
-	// Perform the HTTP request.
-	if _, err := getResponse("DELETE", "/topics/"+id, nil); err != nil {
+// Delete deletes the specified topic from the server.
+func Delete(name string) error {
+	unlock, err := lockCache()
+	if err != nil {
 		return err
 	}
+	defer unlock()
 
-	// On the system.
-	writeTopics(actual)
-	file := filepath.Join(home(), dirName, oldDir, name+".md")
-	_ = os.RemoveAll(file)
-	file = filepath.Join(home(), dirName, newDir, name+".md")
-	_ = os.RemoveAll(file)
-	return nil
+	return deleteTopic(name)
 }
 
 // Rename changes the name of the given topic with the new one.
 func Rename(oldName, newName string) error {
-	var topics []Topic
-	var id string
-
-	readTopics(&topics)
-	for k, v := range topics {
-		if v.Name == oldName {
-			id = v.ID
-			topics[k].Name = newName
-		}
-	}
-	if id == "" {
-		return unknownTopic(oldName)
-	}
-
-	// Perform the HTTP Request.
-	t := &Topic{Name: newName}
-	body, _ := json.Marshal(t)
-	res, err := getResponse("PUT", "/topics/"+id, bytes.NewReader(body))
+	unlock, err := lockCache()
 	if err != nil {
 		return err
 	}
-	if err = topicResponse(t, res); err != nil {
-		return NewError("could not rename this topic: " + err.Error())
-	}
+	defer unlock()
 
-	// Update the system.
-	writeTopics(topics)
-	file := filepath.Join(home(), dirName, oldDir)
-	_ = os.Rename(filepath.Join(file, oldName), filepath.Join(file, newName))
-	file = filepath.Join(home(), dirName, newDir)
-	_ = os.Rename(filepath.Join(file, oldName), filepath.Join(file, newName))
-	return nil
+	return renameTopic(oldName, newName)
 }
@@ -97,7 +97,7 @@ func topicServer(opts *testOptions) *httptest.Server {
 			}
 		case "PUT":
 			p := getFromBody(r)
-			id := strings.Split(r.URL.Path, "/")[2]
+			id := strings.TrimPrefix(r.URL.Path, "/topics/")
 			idx := 0
 
 			for k, v := range testTopics {
@@ -116,12 +116,12 @@ func topicServer(opts *testOptions) *httptest.Server {
 				fmt.Fprint(w, string(b))
 			}
 		case "DELETE":
-			parts := strings.Split(r.URL.Path, "/")
+			id := strings.TrimPrefix(r.URL.Path, "/topics/")
 
 			b := testTopics[:0]
 			length := 0
 			for _, v := range testTopics {
-				if v.ID != parts[2] {
+				if v.ID != id {
 					length++
 					b = append(b, v)
 				}
@@ -138,7 +138,7 @@ func topicServer(opts *testOptions) *httptest.Server {
 
 func testList(t *testing.T, expected []string) {
 	var err error
-	res := capture.All(func() { err = List() })
+	res := capture.All(func() { err = List(&ListOptions{}) })
 	if err != nil {
 		t.Fatalf("We were not expecting an error: %v", err)
 	}
@@ -417,7 +417,7 @@ func TestTimeoutRename(t *testing.T) {
 	defer func() { requestTimeout = oldTimeout }()
 	requestTimeout = 200 * time.Millisecond
 
-	if err = Rename("topic1", "topic2"); err == nil {
+	if err = Rename("topic1", "topic3"); err == nil {
 		t.Fatal("We were expecting an error")
 	}
 	if !strings.Contains(err.Error(), "timed out") {
@@ -453,6 +453,170 @@ func TestBadResponseRename(t *testing.T) {
 	}
 }
 
+func TestRenameFiles(t *testing.T) {
+	startTestEnv(t)
+	defer stopTestEnv(t)
+
+	ts := topicServer(nil)
+	defer ts.Close()
+
+	config = &configuration{
+		Server: ts.URL,
+		Token:  "1234",
+	}
+
+	var err error
+	capture.All(func() { err = fetch() })
+	if err != nil {
+		t.Fatalf("We were not expecting an error: %v", err)
+	}
+
+	// Changes that have not been pushed yet are kept.
+	writeIn(t, filepath.Join(home(), dirName, newDir), "topic1.md", "changed")
+	if err = Rename("topic1", "newtopic"); err != nil {
+		t.Fatalf("We were not expecting an error: %v", err)
+	}
+	for _, d := range []string{oldDir, newDir} {
+		dir := filepath.Join(home(), dirName, d)
+		if _, err = os.Stat(filepath.Join(dir, "topic1.md")); !os.IsNotExist(err) {
+			t.Fatalf("The old file should be gone")
+		}
+		if _, err = os.Stat(filepath.Join(dir, "newtopic.md")); err != nil {
+			t.Fatalf("The new file should be there: %v", err)
+		}
+	}
+	if c := readNew(t, "newtopic.md"); c != "changed" {
+		t.Fatalf("Expecting 'changed'; got: %v", c)
+	}
+	if len(createdTopics()) != 0 || len(deletedTopics()) != 0 {
+		t.Fatalf("The topic should not be seen as created or deleted")
+	}
+	changed := changedTopics()
+	if len(changed) != 1 || changed[0].Name != "newtopic" {
+		t.Fatalf("Expecting 'newtopic' to be changed; got: %v", changed)
+	}
+}
+
+func TestRenameCollision(t *testing.T) {
+	startTestEnv(t)
+	defer stopTestEnv(t)
+
+	ts := topicServer(nil)
+	defer ts.Close()
+
+	config = &configuration{
+		Server: ts.URL,
+		Token:  "1234",
+	}
+
+	var err error
+	capture.All(func() { err = fetch() })
+	if err != nil {
+		t.Fatalf("We were not expecting an error: %v", err)
+	}
+
+	err = Rename("topic1", "topic2")
+	if err == nil || !strings.Contains(err.Error(), "there is already a topic named 'topic2'") {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if testTopics[0].Name != "topic1" {
+		t.Fatalf("The topic should not have been renamed on the server")
+	}
+	if c := readNew(t, "topic1.md"); c != "1111" {
+		t.Fatalf("Expecting '1111'; got: %v", c)
+	}
+}
+
+func TestRenameRollback(t *testing.T) {
+	startTestEnv(t)
+	defer stopTestEnv(t)
+
+	ts := topicServer(&testOptions{BadResponse: true})
+	defer ts.Close()
+
+	config = &configuration{
+		Server: ts.URL,
+		Token:  "1234",
+	}
+
+	var err error
+	capture.All(func() { err = fetch() })
+	if err != nil {
+		t.Fatalf("We were not expecting an error: %v", err)
+	}
+	if err = Rename("topic1", "topic"); err == nil {
+		t.Fatalf("We were expecting an error!")
+	}
+
+	var topics []Topic
+	readTopics(&topics)
+	if topics[0].Name != "topic1" {
+		t.Fatalf("Expecting 'topic1'; got: %v", topics[0].Name)
+	}
+	for _, d := range []string{oldDir, newDir} {
+		dir := filepath.Join(home(), dirName, d)
+		if _, err = os.Stat(filepath.Join(dir, "topic1.md")); err != nil {
+			t.Fatalf("The old file should be there: %v", err)
+		}
+		if _, err = os.Stat(filepath.Join(dir, "topic.md")); !os.IsNotExist(err) {
+			t.Fatalf("The new file should not be there")
+		}
+	}
+}
+
+func TestNamespaces(t *testing.T) {
+	startTestEnv(t)
+	defer stopTestEnv(t)
+
+	ts := topicServer(nil)
+	defer ts.Close()
+
+	config = &configuration{
+		Server: ts.URL,
+		Token:  "1234",
+	}
+
+	for _, name := range []string{"work/backend", "home/garden", "work/frontend"} {
+		if err := Create(name); err != nil {
+			t.Fatalf("We were not expecting an error: %v", err)
+		}
+	}
+	testList(t, []string{
+		"Fetching the topics from the server.",
+		"topic1",
+		"topic2",
+		"work/",
+		"  backend",
+		"  frontend",
+		"home/",
+		"  garden",
+	})
+
+	var err error
+	res := capture.All(func() { err = List(&ListOptions{Flat: true}) })
+	if err != nil {
+		t.Fatalf("We were not expecting an error: %v", err)
+	}
+	output := strings.Split(strings.TrimSpace(string(res.Stdout)), "\n")
+	compareSlices(t, output[1:], []string{"topic1", "topic2", "work/backend", "home/garden", "work/frontend"})
+
+	// Topics are stored in subdirectories, and changes are detected there.
+	dir := filepath.Join(home(), dirName, newDir)
+	writeIn(t, filepath.Join(dir, "work"), "backend.md", "changed")
+	changed := changedTopics()
+	if len(changed) != 1 || changed[0].Name != "work/backend" {
+		t.Fatalf("Expecting 'work/backend' to be changed; got: %v", changed)
+	}
+
+	// Empty namespaces are removed.
+	if err = Delete("home/garden"); err != nil {
+		t.Fatalf("We were not expecting an error: %v", err)
+	}
+	if _, err = os.Stat(filepath.Join(dir, "home")); !os.IsNotExist(err) {
+		t.Fatalf("The 'home' directory should be gone")
+	}
+}
+
 func TestEdit(t *testing.T) {
 	startTestEnv(t)
 	defer stopTestEnv(t)
@@ -467,8 +631,8 @@ func TestEdit(t *testing.T) {
 
 	oldCommand := editCommand
 	defer func() { editCommand = oldCommand }()
-	editCommand = func() error {
-		path := filepath.Join(home(), dirName, newDir, "topic1.md")
+	editCommand = func(dir string, files []string) error {
+		path := filepath.Join(dir, "topic1.md")
 		return ioutil.WriteFile(path, []byte("contents"), 0755)
 	}
 
@@ -493,6 +657,75 @@ func TestEdit(t *testing.T) {
 	}
 }
 
+func TestEditTopics(t *testing.T) {
+	startTestEnv(t)
+	defer stopTestEnv(t)
+
+	ts := topicServer(nil)
+	defer ts.Close()
+
+	config = &configuration{
+		Server: ts.URL,
+		Token:  "1234",
+	}
+
+	var opened []string
+	oldCommand := editCommand
+	defer func() { editCommand = oldCommand }()
+	editCommand = func(dir string, files []string) error {
+		opened = files
+		writeIn(t, dir, "topic1.md", "one")
+		writeIn(t, dir, "topic2.md", "two")
+		return nil
+	}
+
+	// Only the given topic is opened and pushed.
+	var err error
+	capture.All(func() { err = Edit("topic2") })
+	if err != nil {
+		t.Fatalf("Not expecting error: %v", err)
+	}
+	compareSlices(t, opened, []string{"topic2.md"})
+	if testTopics[0].Contents != "1111" || testTopics[1].Contents != "two" {
+		t.Fatalf("Unexpected topics: %v", testTopics)
+	}
+	if c := readNew(t, "topic1.md"); c != "1111" {
+		t.Fatalf("Expecting '1111'; got: %v", c)
+	}
+
+	// Globs and similar names.
+	capture.All(func() { err = Edit("topic*", "topc2") })
+	if err != nil {
+		t.Fatalf("Not expecting error: %v", err)
+	}
+	compareSlices(t, opened, []string{"topic1.md", "topic2.md"})
+	if testTopics[0].Contents != "one" {
+		t.Fatalf("Expecting 'one'; got: %v", testTopics[0].Contents)
+	}
+
+	// Line numbers.
+	setEditor(t, "", "vim")
+	capture.All(func() { err = Edit("topic2:42") })
+	if err != nil {
+		t.Fatalf("Not expecting error: %v", err)
+	}
+	compareSlices(t, opened, []string{"+42", "topic2.md"})
+
+	// Unknown topics.
+	opened = nil
+	capture.All(func() { err = Edit("unknown") })
+	if err == nil || !strings.Contains(err.Error(), "the topic 'unknown' does not exist") {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	capture.All(func() { err = Edit("nope*") })
+	if err == nil || !strings.Contains(err.Error(), "no topic matches 'nope*'") {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if opened != nil {
+		t.Fatalf("The editor should not have been opened")
+	}
+}
+
 func TestEditCommand(t *testing.T) {
 	startTestEnv(t)
 	defer stopTestEnv(t)
@@ -518,3 +751,53 @@ func TestEditCommand(t *testing.T) {
 		"",
 	})
 }
+
+func TestShow(t *testing.T) {
+	startTestEnv(t)
+	defer stopTestEnv(t)
+
+	ts := topicServer(nil)
+	defer ts.Close()
+
+	config = &configuration{
+		Server: ts.URL,
+		Token:  "1234",
+	}
+	testTopics[1].Contents = "# Title\n- [ ] **todo**"
+
+	oldTerminal := isTerminal
+	defer func() { isTerminal = oldTerminal }()
+	oldPager := os.Getenv("PAGER")
+	defer func() { _ = os.Setenv("PAGER", oldPager) }()
+
+	// Plain text when not attached to a terminal.
+	isTerminal = func() bool { return false }
+	var err error
+	res := capture.All(func() { err = Show("topc2") })
+	if err != nil {
+		t.Fatalf("Not expecting error: %v", err)
+	}
+	if string(res.Stdout) != "Title\n[ ] todo\n" {
+		t.Fatalf("Unexpected output: %q", res.Stdout)
+	}
+	if !strings.Contains(string(res.Stderr), "Fetching the topics") || !strings.Contains(string(res.Stderr), "Assuming that you meant 'topic2'") {
+		t.Fatalf("Unexpected output: %q", res.Stderr)
+	}
+
+	// Colors and the pager otherwise.
+	isTerminal = func() bool { return true }
+	errCheck(t, os.Setenv("PAGER", "sed s/^/paged:/"))
+	res = capture.All(func() { err = Show("topic*") })
+	if err != nil {
+		t.Fatalf("Not expecting error: %v", err)
+	}
+	out := string(res.Stdout)
+	if !strings.Contains(out, "paged:1111\n") || !strings.Contains(out, "\x1b[") {
+		t.Fatalf("Unexpected output: %q", out)
+	}
+
+	capture.All(func() { err = Show("unknown") })
+	if err == nil || !strings.Contains(err.Error(), "the topic 'unknown' does not exist") {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,342 @@
+// Copyright (C) 2014-2017 Miquel Sabaté Solà <mikisabate@gmail.com>
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package lib
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"text/template"
+)
+
+// TopicsCommand is the hidden command used by the completion scripts to get
+// the names of the cached topics (see Completion).
+const TopicsCommand = "__topics"
+
+// CompletedFlag describes a flag for the completion scripts.
+type CompletedFlag struct {
+	// The names of the flag, without dashes (e.g. "file" and "f").
+	Names []string
+
+	Usage string
+
+	// Whether the flag takes a value or not.
+	Value bool
+}
+
+// CompletedCommand describes a command for the completion scripts.
+type CompletedCommand struct {
+	Name  string
+	Usage string
+	Flags []CompletedFlag
+
+	// The kind of arguments taken by the command: names of topics, files or
+	// one of the given values. If none of them is set, then the command
+	// doesn't take arguments.
+	Topics bool
+	Files  bool
+	Values []string
+
+	// The subcommands of the command, which are completed instead of its
+	// arguments. Only one level of subcommands is supported.
+	Subcommands []CompletedCommand
+}
+
+// completionData is the data passed to the templates of the completion
+// scripts.
+type completionData struct {
+	Program  string
+	Topics   string
+	Commands []CompletedCommand
+	Flags    []CompletedFlag
+}
+
+// dashed returns the given name of a flag with its dashes.
+func dashed(name string) string {
+	if len(name) == 1 {
+		return "-" + name
+	}
+	return "--" + name
+}
+
+// valueFlags returns a shell pattern matching the given flags that take a
+// value.
+func valueFlags(flags []CompletedFlag) string {
+	var patterns []string
+
+	for _, f := range flags {
+		if f.Value {
+			for _, n := range f.Names {
+				patterns = append(patterns, dashed(n))
+			}
+		}
+	}
+	return strings.Join(patterns, "|")
+}
+
+var completionFuncs = template.FuncMap{
+	"dashed":     dashed,
+	"join":       strings.Join,
+	"valueFlags": valueFlags,
+
+	// Returns the names of the given commands separated by spaces.
+	"names": func(commands []CompletedCommand) string {
+		var names []string
+		for _, c := range commands {
+			names = append(names, c.Name)
+		}
+		return strings.Join(names, " ")
+	},
+
+	// Escapes the given text inside of a single-quoted string for the shell.
+	"sh": strings.NewReplacer("'", `'\''`).Replace,
+
+	// Like "sh", but it also escapes the characters with a special meaning
+	// in the descriptions of zsh.
+	"zsh": strings.NewReplacer("'", `'\''`, "[", `\[`, "]", `\]`, ":", `\:`).Replace,
+
+	// Returns the given text as a single-quoted string for fish.
+	"fish": func(text string) string {
+		return "'" + strings.NewReplacer(`\`, `\\`, "'", `\'`).Replace(text) + "'"
+	},
+}
+
+var completionTemplates = map[string]string{
+	"bash": `# Bash completion for {{.Program}}. Generated by '{{.Program}} completion bash'.
+
+_{{.Program}}()
+{
+    local cur="${COMP_WORDS[COMP_CWORD]}" command="" c=1
+
+    # Look for the command, skipping global flags and their values.
+    while [ $c -lt $COMP_CWORD ]; do
+        case "${COMP_WORDS[c]}" in
+        {{- with valueFlags .Flags}}
+        {{.}}) c=$((c+1)) ;;
+        {{- end}}
+        -*) ;;
+        *)  command="${COMP_WORDS[c]}"; break ;;
+        esac
+        c=$((c+1))
+    done
+
+    case "$command" in
+    "")
+        case "$cur" in
+        -*) COMPREPLY=($(compgen -W "{{range .Flags}}{{range .Names}}{{dashed .}} {{end}}{{end}}" -- "$cur")) ;;
+        *)  COMPREPLY=($(compgen -W "{{range .Commands}}{{.Name}} {{end}}" -- "$cur")) ;;
+        esac ;;
+{{- range .Commands}}
+    {{.Name}})
+        {{- if .Subcommands}}
+        # Look for the subcommand, skipping flags.
+        local subcommand="" s=$((c+1))
+        while [ $s -lt $COMP_CWORD ]; do
+            case "${COMP_WORDS[s]}" in
+            -*) ;;
+            *)  subcommand="${COMP_WORDS[s]}"; break ;;
+            esac
+            s=$((s+1))
+        done
+
+        case "$subcommand" in
+        "")
+            case "$cur" in
+            -*) COMPREPLY=($(compgen -W "{{range .Flags}}{{range .Names}}{{dashed .}} {{end}}{{end}}" -- "$cur")) ;;
+            *)  COMPREPLY=($(compgen -W "{{range .Subcommands}}{{.Name}} {{end}}" -- "$cur")) ;;
+            esac ;;
+        {{- range .Subcommands}}
+        {{.Name}})
+            case "$cur" in
+            -*) COMPREPLY=($(compgen -W "{{range .Flags}}{{range .Names}}{{dashed .}} {{end}}{{end}}" -- "$cur")) ;;
+            {{if .Topics -}}
+            *)  local IFS=$'\n'; COMPREPLY=($(compgen -W "$({{$.Topics}})" -- "$cur")) ;;
+            {{- else if .Values -}}
+            *)  COMPREPLY=($(compgen -W "{{join .Values " "}}" -- "$cur")) ;;
+            {{- else -}}
+            *)  COMPREPLY=() ;;
+            {{- end}}
+            esac ;;
+        {{- end}}
+        esac ;;
+        {{- else}}
+        case "$cur" in
+        -*) COMPREPLY=($(compgen -W "{{range .Flags}}{{range .Names}}{{dashed .}} {{end}}{{end}}" -- "$cur")) ;;
+        {{if .Topics -}}
+        *)  local IFS=$'\n'; COMPREPLY=($(compgen -W "$({{$.Topics}})" -- "$cur")) ;;
+        {{- else if .Values -}}
+        *)  COMPREPLY=($(compgen -W "{{join .Values " "}}" -- "$cur")) ;;
+        {{- else -}}
+        *)  COMPREPLY=() ;;
+        {{- end}}
+        esac ;;
+        {{- end}}
+{{- end}}
+    esac
+}
+
+complete -o default -F _{{.Program}} {{.Program}}
+`,
+
+	"zsh": `#compdef {{.Program}}
+# Zsh completion for {{.Program}}. Generated by '{{.Program}} completion zsh'.
+
+_{{.Program}}_topics()
+{
+    local -a topics
+    topics=(${(f)"$({{.Topics}})"})
+    compadd -a topics
+}
+
+_{{.Program}}()
+{
+    local curcontext="$curcontext" state line
+    local -a commands
+    commands=(
+{{- range .Commands}}
+        '{{sh .Name}}:{{sh .Usage}}'
+{{- end}}
+    )
+
+    _arguments -C \
+{{- range .Flags}}{{$f := .}}{{range .Names}}
+        '{{dashed .}}[{{zsh $f.Usage}}]{{if $f.Value}}:value: {{end}}' \
+{{- end}}{{end}}
+        '1: :->command' \
+        '*:: :->args'
+
+    case $state in
+    command)
+        _describe 'command' commands ;;
+    args)
+        case $line[1] in
+{{- range .Commands}}{{if .Subcommands}}
+        {{.Name}})
+            local -a subcommands
+            subcommands=(
+{{- range .Subcommands}}
+                '{{sh .Name}}:{{sh .Usage}}'
+{{- end}}
+            )
+
+            _arguments -C \
+{{- range .Flags}}{{$f := .}}{{range .Names}}
+                '{{dashed .}}[{{zsh $f.Usage}}]{{if $f.Value}}:value: {{end}}' \
+{{- end}}{{end}}
+                '1: :->subcommand' \
+                '*:: :->subargs'
+
+            case $state in
+            subcommand)
+                _describe 'subcommand' subcommands ;;
+            subargs)
+                case $line[1] in
+{{- range .Subcommands}}{{if or .Flags .Topics .Files .Values}}
+                {{.Name}})
+                    _arguments \
+{{- range .Flags}}{{$f := .}}{{range .Names}}
+                        '{{dashed .}}[{{zsh $f.Usage}}]{{if $f.Value}}:value: {{end}}' \
+{{- end}}{{end}}
+{{- if .Topics}}
+                        '*:topic:_{{$.Program}}_topics' \
+{{- else if .Values}}
+                        '1:value:({{join .Values " "}})' \
+{{- else if .Files}}
+                        '*:file:_files' \
+{{- end}}
+                        && return ;;
+{{- end}}{{end}}
+                esac ;;
+            esac ;;
+{{- else if or .Flags .Topics .Files .Values}}
+        {{.Name}})
+            _arguments \
+{{- range .Flags}}{{$f := .}}{{range .Names}}
+                '{{dashed .}}[{{zsh $f.Usage}}]{{if $f.Value}}:value: {{end}}' \
+{{- end}}{{end}}
+{{- if .Topics}}
+                '*:topic:_{{$.Program}}_topics' \
+{{- else if .Values}}
+                '1:value:({{join .Values " "}})' \
+{{- else if .Files}}
+                '*:file:_files' \
+{{- end}}
+                && return ;;
+{{- end}}{{end}}
+        esac ;;
+    esac
+}
+
+_{{.Program}} "$@"
+`,
+
+	"fish": `# Fish completion for {{.Program}}. Generated by '{{.Program}} completion fish'.
+
+function __{{.Program}}_topics
+    {{.Topics}}
+end
+
+complete -c {{.Program}} -f
+{{- range .Flags}}
+complete -c {{$.Program}}{{range .Names}}{{if eq (len .) 1}} -s {{.}}{{else}} -l {{.}}{{end}}{{end}}{{if .Value}} -r{{end}} -d {{fish .Usage}}
+{{- end}}
+{{- range .Commands}}
+complete -c {{$.Program}} -n __fish_use_subcommand -a {{.Name}} -d {{fish .Usage}}
+{{- $seen := printf "'__fish_seen_subcommand_from %v'" .Name}}
+{{- range .Flags}}
+complete -c {{$.Program}} -n {{$seen}}{{range .Names}}{{if eq (len .) 1}} -s {{.}}{{else}} -l {{.}}{{end}}{{end}}{{if .Value}} -r{{end}} -d {{fish .Usage}}
+{{- end}}
+{{- if .Topics}}
+complete -c {{$.Program}} -n {{$seen}} -a '(__{{$.Program}}_topics)'
+{{- else if .Values}}
+complete -c {{$.Program}} -n {{$seen}} -a {{fish (join .Values " ")}}
+{{- else if .Files}}
+complete -c {{$.Program}} -n {{$seen}} -F
+{{- end}}
+{{- $parent := .Name}}
+{{- $none := printf "'__fish_seen_subcommand_from %v; and not __fish_seen_subcommand_from %v'" .Name (names .Subcommands)}}
+{{- range .Subcommands}}
+complete -c {{$.Program}} -n {{$none}} -a {{.Name}} -d {{fish .Usage}}
+{{- $seen := printf "'__fish_seen_subcommand_from %v; and __fish_seen_subcommand_from %v'" $parent .Name}}
+{{- range .Flags}}
+complete -c {{$.Program}} -n {{$seen}}{{range .Names}}{{if eq (len .) 1}} -s {{.}}{{else}} -l {{.}}{{end}}{{end}}{{if .Value}} -r{{end}} -d {{fish .Usage}}
+{{- end}}
+{{- if .Topics}}
+complete -c {{$.Program}} -n {{$seen}} -a '(__{{$.Program}}_topics)'
+{{- else if .Values}}
+complete -c {{$.Program}} -n {{$seen}} -a {{fish (join .Values " ")}}
+{{- else if .Files}}
+complete -c {{$.Program}} -n {{$seen}} -F
+{{- end}}
+{{- end}}
+{{- end}}
+`,
+}
+
+// Completion prints the completion script of the given shell ("bash", "zsh"
+// or "fish") for the given program, with the given commands and global
+// flags. The names of topics are completed through the hidden TopicsCommand
+// command, which only reads the cache.
+func Completion(shell, program string, commands []CompletedCommand, flags []CompletedFlag) error {
+	text, ok := completionTemplates[shell]
+	if !ok {
+		return NewError(fmt.Sprintf("unknown shell '%v'. Use either bash, zsh or fish", shell))
+	}
+
+	tmpl := template.Must(template.New(shell).Funcs(completionFuncs).Parse(text))
+
+	data := &completionData{
+		Program:  program,
+		Topics:   program + " " + TopicsCommand + " 2>/dev/null",
+		Commands: commands,
+		Flags:    flags,
+	}
+	if err := tmpl.Execute(os.Stdout, data); err != nil {
+		return fromError(err)
+	}
+	return nil
+}
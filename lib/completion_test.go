@@ -0,0 +1,103 @@
+// Copyright (C) 2014-2017 Miquel Sabaté Solà <mikisabate@gmail.com>
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package lib
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/mssola/capture"
+)
+
+var (
+	completedCommands = []CompletedCommand{
+		{Name: "show", Usage: "Show [the] topics.", Topics: true},
+		{Name: "export", Usage: "Export them.", Files: true, Flags: []CompletedFlag{
+			{Names: []string{"format"}, Usage: "The 'format'.", Value: true},
+			{Names: []string{"bundle"}, Usage: "One file."},
+		}},
+		{Name: "completion", Usage: "Completion.", Values: []string{"bash", "zsh"}},
+		{Name: "config", Usage: "Settings.", Subcommands: []CompletedCommand{
+			{Name: "get", Usage: "Get one.", Values: []string{"color", "editor"}},
+			{Name: "list", Usage: "List them."},
+		}},
+	}
+	completedFlags = []CompletedFlag{
+		{Names: []string{"file", "f"}, Usage: "A file.", Value: true},
+		{Names: []string{"insecure"}, Usage: "Insecure."},
+	}
+)
+
+func completion(t *testing.T, shell string) string {
+	var err error
+	res := capture.All(func() { err = Completion(shell, "td", completedCommands, completedFlags) })
+	errCheck(t, err)
+	return string(res.Stdout)
+}
+
+func checkScript(t *testing.T, script string, expected []string) {
+	for _, e := range expected {
+		if !strings.Contains(script, e) {
+			t.Fatalf("Expected to find %q in:\n%v", e, script)
+		}
+	}
+}
+
+func TestCompletionBash(t *testing.T) {
+	checkScript(t, completion(t, "bash"), []string{
+		"--file|-f) c=$((c+1)) ;;",
+		`compgen -W "--file -f --insecure "`,
+		`compgen -W "show export completion config "`,
+		"    show)\n",
+		`compgen -W "$(td __topics 2>/dev/null)"`,
+		`compgen -W "--format --bundle "`,
+		`compgen -W "bash zsh"`,
+		`subcommand="${COMP_WORDS[s]}"`,
+		`compgen -W "get list "`,
+		"        get)\n",
+		`compgen -W "color editor"`,
+		"complete -o default -F _td td",
+	})
+}
+
+func TestCompletionZsh(t *testing.T) {
+	checkScript(t, completion(t, "zsh"), []string{
+		"#compdef td",
+		"'show:Show [the] topics.'",
+		`'--file[A file.]:value: '`,
+		`'-f[A file.]:value: '`,
+		`'--insecure[Insecure.]'`,
+		`'*:topic:_td_topics'`,
+		`'--format[The '\''format'\''.]:value: '`,
+		`'*:file:_files'`,
+		`'1:value:(bash zsh)'`,
+		"'get:Get one.'",
+		"_describe 'subcommand' subcommands",
+		`'1:value:(color editor)'`,
+	})
+}
+
+func TestCompletionFish(t *testing.T) {
+	checkScript(t, completion(t, "fish"), []string{
+		"    td __topics 2>/dev/null\n",
+		"complete -c td -l file -s f -r -d 'A file.'",
+		"complete -c td -n __fish_use_subcommand -a show -d 'Show [the] topics.'",
+		"complete -c td -n '__fish_seen_subcommand_from show' -a '(__td_topics)'",
+		`complete -c td -n '__fish_seen_subcommand_from export' -l format -r -d 'The \'format\'.'`,
+		"complete -c td -n '__fish_seen_subcommand_from export' -F",
+		"complete -c td -n '__fish_seen_subcommand_from completion' -a 'bash zsh'",
+		"complete -c td -n '__fish_seen_subcommand_from config; and not __fish_seen_subcommand_from get list' -a get -d 'Get one.'",
+		"complete -c td -n '__fish_seen_subcommand_from config; and __fish_seen_subcommand_from get' -a 'color editor'",
+	})
+}
+
+func TestCompletionUnknownShell(t *testing.T) {
+	err := Completion("tcsh", "td", completedCommands, completedFlags)
+	if err == nil || !strings.Contains(err.Error(), "unknown shell 'tcsh'") {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+}
@@ -11,12 +11,48 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"time"
 )
 
 type configuration struct {
+	Server string   `json:"server"`
+	Token  string   `json:"token"`
+	Ignore []string `json:"ignore,omitempty"`
+
+	// Other servers that the user has logged in to (see LoginProfile),
+	// indexed by the name of the profile.
+	Profiles map[string]*profile `json:"profiles,omitempty"`
+
+	// The arguments passed to the editor for the -f/--file flag. The
+	// "{file}" token is replaced by the path of the given file.
+	FileTemplate string `json:"file_template,omitempty"`
+
+	// How long the cached topics are considered fresh (e.g. "5m"). Commands
+	// like `list` don't contact the server while the cache is fresh.
+	CacheTTL string `json:"cache_ttl,omitempty"`
+
+	// Other settings that can be managed with the `config` command (see
+	// settings).
+	CAFile     string `json:"ca_file,omitempty"`
+	Color      string `json:"color,omitempty"`
+	Editor     string `json:"editor,omitempty"`
+	File       string `json:"file,omitempty"`
+	Insecure   *bool  `json:"insecure,omitempty"`
+	ListFormat string `json:"list_format,omitempty"`
+	Timeout    string `json:"timeout,omitempty"`
+	TLSVerify  *bool  `json:"tls_verify,omitempty"`
+
+	logged bool
+
+	// The session from the configuration file when it has been replaced by
+	// the one given by the environment (see useEnvSession).
+	file *profile
+}
+
+// profile contains the credentials for a server.
+type profile struct {
 	Server string `json:"server"`
 	Token  string `json:"token"`
-	logged bool
 }
 
 const (
@@ -27,16 +63,30 @@ var (
 	config *configuration
 )
 
-// Initialize performs the needed initialization for the application.
+// Initialize performs the needed initialization for the application. The
+// command being run is not known yet, so any message goes to the standard
+// error, where it never gets mixed with the data of a command (see
+// dataOnStdout).
 func Initialize() {
 	config = &configuration{logged: false}
+	dataOnStdout = true
+	defer func() { dataOnStdout = false }()
+
+	// Files from previous versions might live in the old directory.
+	migrateDirs()
 
 	// Check out the file system. We do this so we can make sure that
 	// any following command touching the file system can do it safely.
 	if err := initFS(); err == nil {
 		// And initialize the "config" global variable.
 		initConfig()
+		useEnvSession()
+
+		// Files from previous versions might need to be renamed.
+		migrateFiles()
 	}
+	checkSettings()
+	applySettings()
 }
 
 func initFS() error {
@@ -50,7 +100,8 @@ func initFS() error {
 }
 
 func checkDir(dir string) error {
-	s := filepath.Join(home(), dirName, dir)
+	s := dataPath(dir)
+	restoreDir(s)
 	if _, err := os.Stat(s); err != nil {
 		if os.IsNotExist(err) {
 			_ = os.MkdirAll(s, 0755)
@@ -84,7 +135,7 @@ func initConfig() {
 
 func configFile() (string, error) {
 	// Create the config file if it doesn't exist yet.
-	cfg := filepath.Join(home(), dirName, configName)
+	cfg := configPath(configName)
 	if _, err := os.Stat(cfg); os.IsNotExist(err) {
 		dir := filepath.Dir(cfg)
 		_ = os.MkdirAll(dir, 0755)
@@ -98,15 +149,32 @@ func configFile() (string, error) {
 	return cfg, nil
 }
 
-func saveConfig() error {
-	body, _ := json.Marshal(config)
-	filePath, _ := configFile()
+// cacheTTL returns the duration from the "cache_ttl" setting. Malformed or
+// negative values are ignored.
+func (c *configuration) cacheTTL() time.Duration {
+	ttl, err := time.ParseDuration(c.CacheTTL)
+	if err != nil || ttl < 0 {
+		return 0
+	}
+	return ttl
+}
+
+// saved returns the configuration as it has to be saved, that is, with the
+// session from the configuration file instead of the one given by the
+// environment (see useEnvSession).
+func (c *configuration) saved() *configuration {
+	cfg := *c
+	if c.file != nil {
+		cfg.Server, cfg.Token = c.file.Server, c.file.Token
+	}
+	return &cfg
+}
 
-	f, err := os.Create(filePath)
+func saveConfig() error {
+	body, _ := json.Marshal(config.saved())
+	filePath, err := configFile()
 	if err != nil {
 		return err
 	}
-	_, _ = f.Write(body)
-	_ = f.Close()
-	return nil
+	return writeFile(filePath, body, 0644)
 }
@@ -10,6 +10,7 @@ import (
 	"encoding/json"
 	"os"
 	"testing"
+	"time"
 )
 
 func TestInitialize(t *testing.T) {
@@ -126,3 +127,19 @@ func TestInitConfig(t *testing.T) {
 	errCheck(t, os.Setenv("TD", ""))
 	dirName = ".td"
 }
+
+func TestCacheTTL(t *testing.T) {
+	values := map[string]time.Duration{
+		"":      0,
+		"5m":    5 * time.Minute,
+		"1h30m": 90 * time.Minute,
+		"-5m":   0,
+		"five":  0,
+	}
+	for value, expected := range values {
+		cfg := &configuration{CacheTTL: value}
+		if ttl := cfg.cacheTTL(); ttl != expected {
+			t.Fatalf("Expected %v for '%v', got %v", expected, value, ttl)
+		}
+	}
+}
@@ -0,0 +1,230 @@
+// Copyright (C) 2014-2017 Miquel Sabaté Solà <mikisabate@gmail.com>
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package lib
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+const (
+	// The fallback editor in case that no editor has been set and none of the
+	// fallbackEditors could be found.
+	defaultEditor = "vi"
+
+	// The token to be replaced by the path of the file given through the
+	// -f/--file flag on the "file_template" setting.
+	fileToken = "{file}"
+)
+
+var (
+	// File specifies which file the `edit` command should pick in order to
+	// execute commands in the editor during initialization. It's set from the
+	// "file" setting (see applySettings).
+	File = ""
+
+	// The editors to be tried in order when neither the $VISUAL nor the
+	// $EDITOR environment variables are set.
+	fallbackEditors = []string{"vi", "vim", "nvim", "nano", "emacs"}
+)
+
+// Returns the command to be executed in order to open the editor. This is
+// taken from the "editor" setting, then from the $VISUAL environment variable
+// or, if it's not set, from the $EDITOR environment variable. These values are
+// parsed as shell words, so they can contain arguments (e.g. "code --wait").
+// If none of them are set, then the first editor from "fallbackEditors" that
+// can be found will be picked. If none can be found, then it will return the
+// value of the "defaultEditor" constant.
+func editor() []string {
+	if value := settingValue("editor"); value != "" {
+		// The setting has already been validated.
+		words, _ := shellWords(value)
+		return words
+	}
+
+	for _, env := range []string{"VISUAL", "EDITOR"} {
+		value := os.Getenv(env)
+		if strings.TrimSpace(value) == "" {
+			continue
+		}
+		words, err := shellWords(value)
+		if err != nil {
+			warning("could not parse $"+env+": %v.", err.Error())
+			continue
+		}
+		return words
+	}
+
+	for _, e := range fallbackEditors {
+		if _, err := exec.LookPath(e); err == nil {
+			return []string{e}
+		}
+	}
+	return []string{defaultEditor}
+}
+
+// editorName returns the name of the given editor command without the path
+// to it (e.g. "/usr/bin/vim" becomes "vim").
+func editorName(cmd []string) string {
+	return filepath.Base(cmd[0])
+}
+
+// Returns the arguments that have to be passed to the editor in order to
+// execute the commands from the file given through the -f/--file flag. If the
+// "file_template" setting is set, then it's used to build these arguments.
+// Otherwise, the arguments are picked depending on the editor being used (see
+// scriptArguments).
+func editorArguments() []string {
+	if File == "" {
+		return []string{}
+	}
+
+	if _, err := os.Stat(File); os.IsNotExist(err) {
+		warning("given file '%s' does not exist.", File)
+		return []string{}
+	}
+	abs, err := filepath.Abs(File)
+	if err != nil {
+		abs = File
+	}
+
+	if template := settingValue("file_template"); template != "" {
+		words, _ := shellWords(template)
+		for k, w := range words {
+			words[k] = strings.Replace(w, fileToken, abs, -1)
+		}
+		return words
+	}
+
+	name := editorName(editor())
+	args := scriptArguments(name, abs)
+	if args == nil {
+		warning("the -f/--file flag is not supported for '%v'. You can set "+
+			"the 'file_template' setting in the config file instead.", name)
+		return []string{}
+	}
+	return args
+}
+
+// scriptArguments returns the arguments that tell the editor with the given
+// name to execute the given file on startup. Vim and Neovim get script files
+// (.vim, and also .lua for Neovim) through the -S flag, and any other file is
+// read as typed keys through the -s flag. Emacs loads the file as Emacs Lisp.
+// It returns nil for unsupported editors.
+func scriptArguments(name, file string) []string {
+	ext := strings.ToLower(filepath.Ext(file))
+
+	switch name {
+	case "vi", "vim", "gvim", "mvim":
+		if ext == ".vim" {
+			return []string{"-S", file}
+		}
+		return []string{"-s", file}
+	case "nvim", "gnvim":
+		if ext == ".vim" || ext == ".lua" {
+			return []string{"-S", file}
+		}
+		return []string{"-s", file}
+	case "emacs":
+		return []string{"--load", file}
+	}
+	return nil
+}
+
+// fileArguments returns the arguments to be passed to the editor in order to
+// open the given files. The given lines map files to the line where the cursor
+// has to be placed. The syntax for this depends on the editor being used. Note
+// that some editors (e.g. Vim) only honor the line of the first file.
+func fileArguments(files []string, lines map[string]int) []string {
+	var args []string
+
+	name := editorName(editor())
+	for _, file := range files {
+		line, ok := lines[file]
+		if !ok || line <= 0 {
+			args = append(args, file)
+			continue
+		}
+
+		n := strconv.Itoa(line)
+		switch name {
+		case "code", "code-insiders", "codium", "vscodium":
+			args = append(args, "--goto", file+":"+n)
+		case "subl", "sublime_text", "atom", "hx", "helix", "zed":
+			args = append(args, file+":"+n)
+		case "kate":
+			args = append(args, "--line", n, file)
+		default:
+			// vi, vim, nvim, emacs, emacsclient, nano, micro, joe, mg...
+			args = append(args, "+"+n, file)
+		}
+	}
+	return args
+}
+
+// shellWords splits the given string into words as a POSIX shell would do it.
+// That is, words are separated by spaces unless they are quoted with single
+// or double quotes, or escaped with a backslash.
+func shellWords(str string) ([]string, error) {
+	var words []string
+	var word strings.Builder
+	var quote rune
+	inWord, escaped := false, false
+
+	for _, r := range str {
+		switch {
+		case escaped:
+			// Inside of double quotes, backslashes only escape some
+			// characters.
+			if quote == '"' && !strings.ContainsRune("$`\"\\\n", r) {
+				word.WriteRune('\\')
+			}
+			word.WriteRune(r)
+			escaped = false
+		case quote == '\'':
+			if r == '\'' {
+				quote = 0
+			} else {
+				word.WriteRune(r)
+			}
+		case quote == '"':
+			if r == '"' {
+				quote = 0
+			} else if r == '\\' {
+				escaped = true
+			} else {
+				word.WriteRune(r)
+			}
+		case r == '\\':
+			escaped, inWord = true, true
+		case r == '\'' || r == '"':
+			quote, inWord = r, true
+		case unicode.IsSpace(r):
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		default:
+			word.WriteRune(r)
+			inWord = true
+		}
+	}
+
+	if escaped || quote != 0 {
+		return nil, errors.New("unterminated quote or escape")
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+	return words, nil
+}
@@ -0,0 +1,182 @@
+// Copyright (C) 2014-2017 Miquel Sabaté Solà <mikisabate@gmail.com>
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package lib
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mssola/capture"
+)
+
+// setEditor sets the $VISUAL and $EDITOR environment variables for the
+// current test, restoring their previous values afterwards.
+func setEditor(t *testing.T, visual, editor string) {
+	for env, value := range map[string]string{"VISUAL": visual, "EDITOR": editor} {
+		old, ok := os.LookupEnv(env)
+		t.Cleanup(func() {
+			if ok {
+				_ = os.Setenv(env, old)
+			} else {
+				_ = os.Unsetenv(env)
+			}
+		})
+		errCheck(t, os.Setenv(env, value))
+	}
+}
+
+func TestEditor(t *testing.T) {
+	setEditor(t, "", "emacs")
+	compareSlices(t, editor(), []string{"emacs"})
+
+	setEditor(t, "code --wait", "emacs")
+	compareSlices(t, editor(), []string{"code", "--wait"})
+
+	setEditor(t, "'unterminated", "nano")
+	var cmd []string
+	res := capture.All(func() { cmd = editor() })
+	compareSlices(t, cmd, []string{"nano"})
+	if !strings.Contains(string(res.Stdout), "could not parse $VISUAL") {
+		t.Fatalf("Expecting a warning; got: %s", res.Stdout)
+	}
+}
+
+func TestEditorFallback(t *testing.T) {
+	setEditor(t, "", "")
+
+	dir, err := ioutil.TempDir("", "td-path")
+	errCheck(t, err)
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	oldPath := os.Getenv("PATH")
+	defer func() { _ = os.Setenv("PATH", oldPath) }()
+	errCheck(t, os.Setenv("PATH", dir))
+
+	// Nothing could be found.
+	compareSlices(t, editor(), []string{defaultEditor})
+
+	// The first one available is picked.
+	for _, name := range []string{"nano", "emacs"} {
+		errCheck(t, ioutil.WriteFile(filepath.Join(dir, name), []byte("#!/bin/sh\n"), 0755))
+	}
+	compareSlices(t, editor(), []string{"nano"})
+}
+
+func TestEditorArguments(t *testing.T) {
+	startTestEnv(t)
+	defer stopTestEnv(t)
+	defer func() { File = "" }()
+
+	File = ""
+	setEditor(t, "", "vim")
+	args := editorArguments()
+	if len(args) != 0 {
+		t.Fatalf("Expecting no arguments, got %v", len(args))
+	}
+
+	File = "something"
+	res := capture.All(func() { args = editorArguments() })
+	if len(args) != 0 {
+		t.Fatalf("Expecting no arguments, got %v", len(args))
+	}
+	str := "given file 'something' does not exist"
+	if !strings.Contains(string(res.Stdout), str) {
+		t.Fatalf("Expecting '%s'; got: %s", str, res.Stdout)
+	}
+
+	dir, err := ioutil.TempDir("", "td-file")
+	errCheck(t, err)
+	defer func() { _ = os.RemoveAll(dir) }()
+	keys, script, lua := filepath.Join(dir, "keys"), filepath.Join(dir, "init.vim"), filepath.Join(dir, "init.lua")
+	for _, f := range []string{keys, script, lua} {
+		errCheck(t, ioutil.WriteFile(f, []byte(""), 0644))
+	}
+
+	tests := []struct {
+		editor, file string
+		expected     []string
+	}{
+		{"vim", keys, []string{"-s", keys}},
+		{"/usr/bin/vim", script, []string{"-S", script}},
+		{"vim", lua, []string{"-s", lua}},
+		{"nvim", lua, []string{"-S", lua}},
+		{"nvim -p", script, []string{"-S", script}},
+		{"emacs -nw", script, []string{"--load", script}},
+	}
+	for _, test := range tests {
+		setEditor(t, test.editor, "")
+		File = test.file
+		compareSlices(t, editorArguments(), test.expected)
+	}
+
+	// Unsupported editors.
+	setEditor(t, "nano", "")
+	res = capture.All(func() { args = editorArguments() })
+	if len(args) != 0 {
+		t.Fatalf("Expecting no arguments, got %v", len(args))
+	}
+	str = "the -f/--file flag is not supported for 'nano'"
+	if !strings.Contains(string(res.Stdout), str) {
+		t.Fatalf("Expecting '%s'; got: %s", str, res.Stdout)
+	}
+
+	// The template from the configuration.
+	config.FileTemplate = "--rcfile {file} -x"
+	compareSlices(t, editorArguments(), []string{"--rcfile", script, "-x"})
+}
+
+func TestFileArguments(t *testing.T) {
+	files := []string{"a.md", "b.md"}
+	lines := map[string]int{"a.md": 42}
+
+	tests := []struct {
+		editor   string
+		expected []string
+	}{
+		{"vim", []string{"+42", "a.md", "b.md"}},
+		{"/usr/bin/nvim", []string{"+42", "a.md", "b.md"}},
+		{"code --wait", []string{"--goto", "a.md:42", "b.md"}},
+		{"subl -w", []string{"a.md:42", "b.md"}},
+		{"kate", []string{"--line", "42", "a.md", "b.md"}},
+	}
+
+	for _, test := range tests {
+		setEditor(t, test.editor, "")
+		compareSlices(t, fileArguments(files, lines), test.expected)
+	}
+	compareSlices(t, fileArguments(files, nil), files)
+}
+
+func TestShellWords(t *testing.T) {
+	tests := []struct {
+		str      string
+		expected []string
+	}{
+		{"vim", []string{"vim"}},
+		{"  code   --wait ", []string{"code", "--wait"}},
+		{`"/opt/My Editor/bin/ed" -n`, []string{"/opt/My Editor/bin/ed", "-n"}},
+		{`emacsclient -a '' -t`, []string{"emacsclient", "-a", "", "-t"}},
+		{`my\ editor "a\"b" "c\d"`, []string{"my editor", `a"b`, `c\d`}},
+	}
+
+	for _, test := range tests {
+		words, err := shellWords(test.str)
+		if err != nil {
+			t.Fatalf("Not expecting error for '%v': %v", test.str, err)
+		}
+		compareSlices(t, words, test.expected)
+	}
+
+	for _, str := range []string{`"vim`, "'vim", `vim\`} {
+		if _, err := shellWords(str); err == nil {
+			t.Fatalf("Expecting error for '%v'", str)
+		}
+	}
+}
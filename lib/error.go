@@ -36,6 +36,15 @@ func fromError(err error) *Error {
 	return NewError(err.Error())
 }
 
+// errorMessage returns the message of the given error without any decoration,
+// so it can be embedded into other messages.
+func errorMessage(err error) string {
+	if e, ok := err.(*Error); ok {
+		return e.message
+	}
+	return err.Error()
+}
+
 // So we implement the Stringer interface.
 func (e *Error) String() string {
 	red := &colors.Color{
@@ -46,9 +55,9 @@ func (e *Error) String() string {
 	white := colors.Default()
 	white.SetMode(colors.Bold)
 
-	str := fmt.Sprintf("%v %v.", red.Get("error:"), e.message)
+	str := fmt.Sprintf("%v %v.", colored(red, "error:"), e.message)
 	if e.see != "" {
-		str += fmt.Sprintf(" %v 'td %v'.", white.Get("See:"), e.see)
+		str += fmt.Sprintf(" %v 'td %v'.", colored(white, "See:"), e.see)
 	}
 	return str + "\n"
 }
@@ -67,8 +76,8 @@ func warning(str, extra string) {
 	}
 	str = "%v: " + str + "\n"
 	if extra == "" {
-		fmt.Printf(str, red.Get("warning"))
+		progress(str, colored(red, "warning"))
 	} else {
-		fmt.Printf(str, red.Get("warning"), extra)
+		progress(str, colored(red, "warning"), extra)
 	}
 }
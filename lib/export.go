@@ -0,0 +1,139 @@
+// Copyright (C) 2014-2017 Miquel Sabaté Solà <mikisabate@gmail.com>
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package lib
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+)
+
+// ExportOptions contains the options of the `export` command.
+type ExportOptions struct {
+	// The format of the export: "json", "dir", "tar" or "html".
+	Format string
+
+	// How topics are sorted: either by "name" or by "created" date.
+	Sort string
+
+	// Write a single file instead of a directory. Only for HTML exports.
+	Bundle bool
+
+	// Always render the markdown of topics with the builtin renderer, even
+	// if the server provides the rendered HTML. Only for HTML exports.
+	Builtin bool
+}
+
+// Export writes all the topics into the given path in the format specified by
+// the given options. For the "json" and the "tar" formats, the path can be
+// empty or "-", and then the export is written into the standard output.
+func Export(path string, opts *ExportOptions) error {
+	if opts.Sort != "" && opts.Sort != "name" && opts.Sort != "created" {
+		return NewError(fmt.Sprintf("unknown sorting '%v'", opts.Sort))
+	}
+	stdout := path == "" || path == "-"
+	if stdout {
+		dataOnStdout = true
+		defer func() { dataOnStdout = false }()
+	}
+
+	switch opts.Format {
+	case "json", "tar":
+	case "dir", "html":
+		if stdout {
+			return NewError(fmt.Sprintf("the '%v' format requires a path", opts.Format))
+		}
+	default:
+		return NewError(fmt.Sprintf("unknown format '%v'", opts.Format))
+	}
+
+	topics, err := exportTopics(opts.Sort)
+	if err != nil {
+		return err
+	}
+
+	var buffer bytes.Buffer
+	switch opts.Format {
+	case "json":
+		for k := range topics {
+			topics[k].Markdown = ""
+		}
+		body, _ := json.MarshalIndent(topics, "", "  ")
+		buffer.Write(append(body, '\n'))
+	case "tar":
+		err = writeTar(&buffer, topicEntries(topics), !stdout && compressed(path))
+	case "dir":
+		err = writeEntries(path, topicEntries(topics))
+	case "html":
+		err = exportHTML(topics, path, opts.Bundle, opts.Builtin)
+	}
+	if err != nil {
+		return fromError(err)
+	}
+
+	// The "json" and the "tar" formats have been written into the buffer.
+	if buffer.Len() > 0 {
+		if stdout {
+			_, err = os.Stdout.Write(buffer.Bytes())
+		} else {
+			err = writeFile(path, buffer.Bytes(), 0644)
+		}
+		if err != nil {
+			return fromError(err)
+		}
+	}
+	if !stdout {
+		fmt.Printf("Exported %v topics into '%v'.\n", len(topics), path)
+	}
+	return nil
+}
+
+// exportTopics returns the cached topics with their contents, sorted as
+// given. The topics are fetched first if possible. The HTML rendered by the
+// server is kept for the topics that have not been changed locally.
+func exportTopics(sorting string) ([]Topic, error) {
+	unlock, err := lockCache()
+	if err != nil {
+		return nil, err
+	}
+
+	// Note that saving the topics clears their contents, so we keep a copy.
+	remote := make(map[string]Topic)
+	fetched, err := serverTopics()
+	for _, t := range fetched {
+		remote[t.ID] = t
+	}
+	if err == nil && safeFetch() {
+		err = save(fetched)
+	}
+	unlock()
+	if err != nil {
+		warning("could not fetch the topics, using the cached ones: %v.", errorMessage(err))
+	}
+
+	var topics []Topic
+	readTopics(&topics)
+	dir := dataPath(newDir)
+	for k, t := range topics {
+		body, _ := ioutil.ReadFile(topicPath(dir, t.Name))
+		topics[k].Contents = string(body)
+		if r, ok := remote[t.ID]; ok && r.Contents == topics[k].Contents {
+			topics[k].Markdown = r.Markdown
+		}
+	}
+
+	sort.SliceStable(topics, func(i, j int) bool {
+		if sorting == "created" {
+			return topics[i].CreatedAt.Before(topics[j].CreatedAt)
+		}
+		return topics[i].Name < topics[j].Name
+	})
+	return topics, nil
+}
@@ -0,0 +1,174 @@
+// Copyright (C) 2014-2017 Miquel Sabaté Solà <mikisabate@gmail.com>
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package lib
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mssola/capture"
+)
+
+// exportServer starts a topic server with some topics to be exported.
+func exportServer(t *testing.T) func() {
+	startTestEnv(t)
+	ts := topicServer(nil)
+	testTopics = []Topic{
+		{ID: "1", Name: "work/backend", Contents: "See [home](home)", CreatedAt: time.Date(2017, 2, 1, 0, 0, 0, 0, time.UTC)},
+		{ID: "2", Name: "home", Contents: "# Home", Markdown: "<h1>From the server</h1>",
+			CreatedAt: time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)},
+	}
+	config = &configuration{Server: ts.URL, Token: "1234"}
+
+	return func() {
+		ts.Close()
+		stopTestEnv(t)
+	}
+}
+
+func readExported(t *testing.T, path string) string {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Could not read '%v': %v", path, err)
+	}
+	return string(contents)
+}
+
+func TestExportHTML(t *testing.T) {
+	defer exportServer(t)()
+
+	dir, err := ioutil.TempDir("", "td-export")
+	errCheck(t, err)
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	capture.All(func() { err = Export(dir, &ExportOptions{Format: "html", Sort: "created"}) })
+	if err != nil {
+		t.Fatalf("Not expecting error: %v", err)
+	}
+
+	// The index is sorted by creation date.
+	index := readExported(t, filepath.Join(dir, "index.html"))
+	home, backend := strings.Index(index, `href="home.html"`), strings.Index(index, `href="work/backend.html"`)
+	if home < 0 || backend < home {
+		t.Fatalf("Unexpected index: %v", index)
+	}
+	if !strings.Contains(index, "2017-02-01") {
+		t.Fatalf("The creation date should be on the index: %v", index)
+	}
+
+	// Pages link to each other and use the HTML from the server if possible.
+	page := readExported(t, filepath.Join(dir, "work", "backend.html"))
+	if !strings.Contains(page, `<a href="../home.html">home</a>`) || !strings.Contains(page, `href="../index.html"`) {
+		t.Fatalf("Unexpected page: %v", page)
+	}
+	page = readExported(t, filepath.Join(dir, "home.html"))
+	if !strings.Contains(page, "From the server") {
+		t.Fatalf("Unexpected page: %v", page)
+	}
+
+	// Unless the builtin renderer is requested.
+	capture.All(func() { err = Export(dir, &ExportOptions{Format: "html", Builtin: true}) })
+	if err != nil {
+		t.Fatalf("Not expecting error: %v", err)
+	}
+	page = readExported(t, filepath.Join(dir, "home.html"))
+	if !strings.Contains(page, "<h1>Home</h1>") {
+		t.Fatalf("Unexpected page: %v", page)
+	}
+}
+
+func TestExportBundle(t *testing.T) {
+	defer exportServer(t)()
+
+	dir, err := ioutil.TempDir("", "td-export")
+	errCheck(t, err)
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	file := filepath.Join(dir, "topics.html")
+	capture.All(func() { err = Export(file, &ExportOptions{Format: "html", Bundle: true}) })
+	if err != nil {
+		t.Fatalf("Not expecting error: %v", err)
+	}
+
+	contents := readExported(t, file)
+	for _, str := range []string{`<section id="topic-1">`, `<a href="#topic-2">home</a>`, `href="#topic-1">work/backend</a>`} {
+		if !strings.Contains(contents, str) {
+			t.Fatalf("Expecting '%v' inside of: %v", str, contents)
+		}
+	}
+}
+
+func TestExportErrors(t *testing.T) {
+	defer exportServer(t)()
+
+	err := Export("somewhere", &ExportOptions{Format: "pdf"})
+	if err == nil || !strings.Contains(err.Error(), "unknown format 'pdf'") {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	err = Export("somewhere", &ExportOptions{Format: "html", Sort: "size"})
+	if err == nil || !strings.Contains(err.Error(), "unknown sorting 'size'") {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+}
+
+func TestExportFormats(t *testing.T) {
+	defer exportServer(t)()
+
+	dir, err := ioutil.TempDir("", "td-export")
+	errCheck(t, err)
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	expected := []Topic{
+		{ID: "2", Name: "home", Contents: "# Home", CreatedAt: testTopics[1].CreatedAt},
+		{ID: "1", Name: "work/backend", Contents: "See [home](home)", CreatedAt: testTopics[0].CreatedAt},
+	}
+
+	// JSON into the standard output, without other messages.
+	res := capture.All(func() { err = Export("", &ExportOptions{Format: "json"}) })
+	if err != nil {
+		t.Fatalf("Not expecting error: %v", err)
+	}
+	var topics []Topic
+	errCheck(t, json.Unmarshal(res.Stdout, &topics))
+	compareTopics(t, topics, expected)
+	if strings.Contains(string(res.Stdout), "markdown") {
+		t.Fatalf("The HTML from the server should not be exported: %s", res.Stdout)
+	}
+
+	// Directories and archives.
+	capture.All(func() { err = Export(filepath.Join(dir, "export"), &ExportOptions{Format: "dir"}) })
+	if err != nil {
+		t.Fatalf("Not expecting error: %v", err)
+	}
+	if c := readExported(t, filepath.Join(dir, "export", "work", "backend.md")); c != expected[1].Contents {
+		t.Fatalf("Unexpected contents: %v", c)
+	}
+
+	file := filepath.Join(dir, "export.tar.gz")
+	capture.All(func() { err = Export(file, &ExportOptions{Format: "tar"}) })
+	if err != nil {
+		t.Fatalf("Not expecting error: %v", err)
+	}
+	f, err := os.Open(file)
+	errCheck(t, err)
+	defer func() { _ = f.Close() }()
+	entries, err := readTar(f, true)
+	errCheck(t, err)
+	topics, err = entriesTopics(entries)
+	errCheck(t, err)
+	compareTopics(t, topics, expected)
+
+	err = Export("-", &ExportOptions{Format: "dir"})
+	if err == nil || !strings.Contains(err.Error(), "the 'dir' format requires a path") {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+}
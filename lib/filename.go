@@ -0,0 +1,165 @@
+// Copyright (C) 2014-2017 Miquel Sabaté Solà <mikisabate@gmail.com>
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package lib
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// The extension of the files containing topics.
+	topicExt = ".md"
+
+	// The characters that are always encoded when building the file name of
+	// a topic. Besides the escape character itself, these are the path
+	// separators and the characters that are not allowed on some file
+	// systems.
+	unsafeChars = "%/\\:*?\"<>|"
+)
+
+// encodeName percent-encodes the unsafe characters from the given name (see
+// topicFile).
+func encodeName(name string) string {
+	var b strings.Builder
+
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		if c < 0x20 || c == 0x7f || strings.IndexByte(unsafeChars, c) >= 0 || (i == 0 && c == '.') {
+			fmt.Fprintf(&b, "%%%02X", c)
+		} else {
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// topicFile returns the path, relative to a cache directory, of the file
+// containing the topic with the given name. Topic names are encoded in a
+// reversible way, so any name is safe to be used as a file name: unsafe
+// characters are percent-encoded (e.g. "100%" becomes "100%25.md"), and
+// everything else is kept as is so the file name is still readable. Topics
+// with namespaces (e.g. "work/backend") are placed in nested directories
+// (e.g. "work/backend.md").
+func topicFile(name string) string {
+	if !namespaced(name) {
+		return flatFile(name)
+	}
+	segments := strings.Split(name, "/")
+	for k, s := range segments {
+		segments[k] = encodeName(s)
+	}
+	return filepath.Join(segments...) + topicExt
+}
+
+// namespaced returns true if the given topic name contains a namespace (e.g.
+// "work/backend"). Names with empty segments (e.g. "a//b" or "/a") are not
+// considered to have a namespace.
+func namespaced(name string) bool {
+	if !strings.Contains(name, "/") {
+		return false
+	}
+	for _, s := range strings.Split(name, "/") {
+		if s == "" {
+			return false
+		}
+	}
+	return true
+}
+
+// flatFile returns the name of the file containing the topic with the given
+// name without taking namespaces into account. That is, slashes are encoded
+// as any other unsafe character.
+func flatFile(name string) string {
+	return encodeName(name) + topicExt
+}
+
+// topicName returns the name of the topic contained in the file with the given
+// path, relative to a cache directory. It returns false if the given file does
+// not belong to a topic. File names that are not properly encoded (e.g.
+// created by the user) are taken literally.
+func topicName(file string) (string, bool) {
+	file = filepath.ToSlash(file)
+	if !strings.HasSuffix(file, topicExt) || strings.HasSuffix(file, "/"+topicExt) || file == topicExt {
+		return "", false
+	}
+
+	segments := strings.Split(strings.TrimSuffix(file, topicExt), "/")
+	for k, s := range segments {
+		if name, err := url.PathUnescape(s); err == nil {
+			segments[k] = name
+		}
+	}
+	return strings.Join(segments, "/"), true
+}
+
+// topicPath returns the path of the file of the given topic inside of the
+// given directory.
+func topicPath(dir, name string) string {
+	return filepath.Join(dir, topicFile(name))
+}
+
+// migrateFiles renames the files of the known topics that were stored with
+// the naming scheme of previous versions. That is, the raw name of the topic,
+// or the encoded name of the topic without namespaces.
+func migrateFiles() {
+	var topics []Topic
+	readTopics(&topics)
+
+	for _, d := range []string{oldDir, newDir} {
+		dir := dataPath(d)
+		for _, t := range topics {
+			enc := topicPath(dir, t.Name)
+			if _, err := os.Stat(enc); !os.IsNotExist(err) {
+				continue
+			}
+
+			for _, legacy := range []string{t.Name + topicExt, flatFile(t.Name)} {
+				old := filepath.Join(dir, legacy)
+				if old == enc || !inside(dir, old) {
+					continue
+				}
+				if _, err := os.Stat(old); err != nil {
+					continue
+				}
+				_ = os.MkdirAll(filepath.Dir(enc), 0755)
+				if err := os.Rename(old, enc); err == nil {
+					pruneDirs(dir, old)
+				}
+				break
+			}
+		}
+	}
+}
+
+// pruneDirs removes the empty directories containing the given path up to
+// the given directory, which is never removed. This is used after removing
+// files of namespaced topics.
+func pruneDirs(dir, path string) {
+	for p := filepath.Dir(path); p != dir && inside(dir, p); p = filepath.Dir(p) {
+		if err := os.Remove(p); err != nil {
+			return
+		}
+	}
+}
+
+// removeTopicFile removes the file of the given topic from the given
+// directory, along with the directories of its namespace that become empty.
+func removeTopicFile(dir, name string) {
+	path := topicPath(dir, name)
+	_ = os.RemoveAll(path)
+	pruneDirs(dir, path)
+}
+
+// inside returns true if the given path is inside of the given directory.
+func inside(dir, path string) bool {
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
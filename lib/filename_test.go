@@ -0,0 +1,115 @@
+// Copyright (C) 2014-2017 Miquel Sabaté Solà <mikisabate@gmail.com>
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package lib
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestTopicFile(t *testing.T) {
+	cases := map[string]string{
+		"topic":       "topic.md",
+		"work/q3":     filepath.Join("work", "q3.md"),
+		"../x":        filepath.Join("%2E.", "x.md"),
+		"a//b":        "a%2F%2Fb.md",
+		"/a":          "%2Fa.md",
+		"100%":        "100%25.md",
+		"a\x00b":      "a%00b.md",
+		"ça va?":      "ça va%3F.md",
+		".hidden":     "%2Ehidden.md",
+		"with spaces": "with spaces.md",
+	}
+
+	for name, file := range cases {
+		if f := topicFile(name); f != file {
+			t.Fatalf("Expected '%v' for '%v'; got '%v'", file, name, f)
+		}
+		if n, ok := topicName(file); !ok || n != name {
+			t.Fatalf("Expected '%v' for '%v'; got '%v'", name, file, n)
+		}
+	}
+
+	if _, ok := topicName("topic.txt"); ok {
+		t.Fatalf("'topic.txt' is not a topic")
+	}
+	if n, ok := topicName("100%.md"); !ok || n != "100%" {
+		t.Fatalf("Expected '100%%'; got '%v'", n)
+	}
+}
+
+func TestUnsafeNames(t *testing.T) {
+	startTestEnv(t)
+	defer stopTestEnv(t)
+
+	ts := topicServer(nil)
+	defer ts.Close()
+	config = &configuration{Server: ts.URL, Token: "1234"}
+
+	for _, name := range []string{"work/q3", "../x"} {
+		errCheck(t, Create(name))
+		path := filepath.Join(home(), dirName, newDir, topicFile(name))
+		if _, err := os.Stat(path); err != nil {
+			t.Fatalf("Expected '%v' to exist: %v", path, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(home(), dirName, "x.md")); !os.IsNotExist(err) {
+		t.Fatalf("A file has been created outside of the cache")
+	}
+
+	errCheck(t, Delete("../x"))
+	path := filepath.Join(home(), dirName, newDir, topicFile("../x"))
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("Expected '%v' to be removed", path)
+	}
+	if err := Create(""); err == nil {
+		t.Fatalf("We were expecting an error")
+	}
+}
+
+func TestMigrateFiles(t *testing.T) {
+	startTestEnv(t)
+	defer stopTestEnv(t)
+
+	Initialize()
+	errCheck(t, writeTopics([]Topic{
+		{ID: "1", Name: "plain"},
+		{ID: "2", Name: "work/q3"},
+		{ID: "3", Name: "100%"},
+		{ID: "4", Name: "../outside"},
+	}))
+	for _, d := range []string{oldDir, newDir} {
+		dir := filepath.Join(home(), dirName, d)
+		writeIn(t, dir, "plain.md", "plain")
+		writeIn(t, dir, "work%2Fq3.md", "q3")
+		writeIn(t, dir, "100%.md", "100")
+	}
+	writeIn(t, filepath.Join(home(), dirName), "outside.md", "outside")
+
+	Initialize()
+	for _, d := range []string{oldDir, newDir} {
+		dir := filepath.Join(home(), dirName, d)
+		for name, contents := range map[string]string{"plain": "plain", "work/q3": "q3", "100%": "100"} {
+			body, err := ioutil.ReadFile(topicPath(dir, name))
+			errCheck(t, err)
+			if string(body) != contents {
+				t.Fatalf("Expected '%v'; got '%s'", contents, body)
+			}
+		}
+		entries, _ := ioutil.ReadDir(dir)
+		if len(entries) != 3 {
+			t.Fatalf("Expected 3 entries; got %v", len(entries))
+		}
+	}
+
+	// Files outside of the cache are never touched.
+	if _, err := os.Stat(filepath.Join(home(), dirName, "outside.md")); err != nil {
+		t.Fatalf("Expected 'outside.md' to be there: %v", err)
+	}
+}
@@ -0,0 +1,97 @@
+// Copyright (C) 2014-2017 Miquel Sabaté Solà <mikisabate@gmail.com>
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package lib
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+)
+
+const (
+	// The name of the directory containing the hooks.
+	hooksDir = "hooks"
+
+	// The hooks being supported. Only the "pre-push" hook can abort what's
+	// being done.
+	prePush    = "pre-push"
+	postFetch  = "post-fetch"
+	postCreate = "post-create"
+	postDelete = "post-delete"
+	postRename = "post-rename"
+)
+
+// hookEvent is what hooks receive as JSON through the standard input.
+type hookEvent struct {
+	Hook   string      `json:"hook"`
+	Server string      `json:"server"`
+	Topics []hookTopic `json:"topics"`
+
+	// The previous name of the topic for the "post-rename" hook.
+	OldName string `json:"old_name,omitempty"`
+}
+
+// hookTopic is a topic as given to hooks.
+type hookTopic struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+
+	// The path of the local copy of the topic, if there is one.
+	Path string `json:"path,omitempty"`
+}
+
+// newHookEvent returns the event for the given hook about the given topics.
+func newHookEvent(hook string, topics []Topic) *hookEvent {
+	event := &hookEvent{Hook: hook, Server: config.Server, Topics: []hookTopic{}}
+	dir := dataPath(newDir)
+
+	for _, t := range topics {
+		ht := hookTopic{ID: t.ID, Name: t.Name}
+		path := topicPath(dir, t.Name)
+		if _, err := os.Stat(path); err == nil {
+			ht.Path = path
+		}
+		event.Topics = append(event.Topics, ht)
+	}
+	return event
+}
+
+// runHook runs the executable for the hook of the given event, if any, with
+// the event as JSON on its standard input. Hooks get the same environment as
+// plugins (see pluginEnv). Non-executable files are ignored, so hooks can be
+// disabled by removing their permission to be executed.
+func runHook(event *hookEvent) error {
+	path := configPath(hooksDir, event.Hook)
+	fi, err := os.Stat(path)
+	if err != nil || !fi.Mode().IsRegular() || fi.Mode()&0111 == 0 {
+		return nil
+	}
+
+	body, _ := json.Marshal(event)
+	cmd := exec.Command(path)
+	cmd.Stdin = bytes.NewReader(body)
+	cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+	if dataOnStdout {
+		cmd.Stdout = os.Stderr
+	}
+	cmd.Env = pluginEnv()
+
+	if err := cmd.Run(); err != nil {
+		return NewError(fmt.Sprintf("the '%v' hook failed: %v", event.Hook, err))
+	}
+	return nil
+}
+
+// postHook runs the hook of the given event, which cannot abort anything, so
+// failures are only reported.
+func postHook(event *hookEvent) {
+	if err := runHook(event); err != nil {
+		warning("%v.", errorMessage(err))
+	}
+}
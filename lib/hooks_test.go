@@ -0,0 +1,143 @@
+// Copyright (C) 2014-2017 Miquel Sabaté Solà <mikisabate@gmail.com>
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package lib
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/mssola/capture"
+)
+
+// writeHook writes a hook that saves the event it receives into a file named
+// after the hook inside of the test directory, and exits with the given
+// status.
+func writeHook(t *testing.T, name string, status int, mode os.FileMode) {
+	dir := configPath(hooksDir)
+	errCheck(t, os.MkdirAll(dir, 0755))
+
+	out := dataPath(name + ".json")
+	script := "#!/bin/sh\ncat > '" + out + "'\nexit " + strconv.Itoa(status) + "\n"
+	_ = os.Remove(filepath.Join(dir, name))
+	errCheck(t, ioutil.WriteFile(filepath.Join(dir, name), []byte(script), mode))
+}
+
+// readEvent returns the event received by the given hook, if any.
+func readEvent(t *testing.T, name string) *hookEvent {
+	body, err := ioutil.ReadFile(dataPath(name + ".json"))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	errCheck(t, err)
+
+	var event hookEvent
+	errCheck(t, json.Unmarshal(body, &event))
+	return &event
+}
+
+func TestHooksCreateRenameDelete(t *testing.T) {
+	startTestEnv(t)
+	defer stopTestEnv(t)
+
+	ts := topicServer(nil)
+	defer ts.Close()
+	config = &configuration{Server: ts.URL, Token: "1234"}
+
+	for _, name := range []string{postCreate, postRename, postDelete} {
+		writeHook(t, name, 0, 0755)
+	}
+
+	errCheck(t, Create("work"))
+	event := readEvent(t, postCreate)
+	if event == nil || event.Hook != postCreate || event.Server != ts.URL || len(event.Topics) != 1 {
+		t.Fatalf("Unexpected event: %+v", event)
+	}
+	path := topicPath(filepath.Join(home(), dirName, newDir), "work")
+	if tp := event.Topics[0]; tp.ID != "work" || tp.Name != "work" || tp.Path != path {
+		t.Fatalf("Unexpected topic: %+v", tp)
+	}
+
+	errCheck(t, Rename("work", "home"))
+	event = readEvent(t, postRename)
+	if event == nil || event.OldName != "work" || event.Topics[0].Name != "home" {
+		t.Fatalf("Unexpected event: %+v", event)
+	}
+
+	errCheck(t, Delete("home"))
+	event = readEvent(t, postDelete)
+	if event == nil || event.Topics[0].Name != "home" || event.Topics[0].Path != "" {
+		t.Fatalf("Unexpected event: %+v", event)
+	}
+}
+
+func TestHooksPrePush(t *testing.T) {
+	startTestEnv(t)
+	defer stopTestEnv(t)
+
+	ts := topicServer(nil)
+	defer ts.Close()
+	config = &configuration{Server: ts.URL, Token: "1234"}
+	_ = capture.All(func() { errCheck(t, fetch()) })
+	writeIn(t, filepath.Join(home(), dirName, newDir), "topic1.md", "changed")
+
+	// The hook aborts the push.
+	writeHook(t, prePush, 1, 0755)
+	var ok bool
+	res := capture.All(func() { ok = pushTopics(changedTopics()) })
+	if ok || testTopics[0].Contents != "1111" {
+		t.Fatalf("Expected the push to be aborted: %v", testTopics)
+	}
+	if !strings.Contains(string(res.Stdout), "the 'pre-push' hook failed") {
+		t.Fatalf("Unexpected output: %v", string(res.Stdout))
+	}
+	event := readEvent(t, prePush)
+	if event == nil || len(event.Topics) != 1 || event.Topics[0].Name != "topic1" {
+		t.Fatalf("Unexpected event: %+v", event)
+	}
+
+	// And now it allows it.
+	writeHook(t, prePush, 0, 0755)
+	_ = capture.All(func() { ok = pushTopics(changedTopics()) })
+	if !ok || testTopics[0].Contents != "changed" {
+		t.Fatalf("Expected the push to succeed: %v", testTopics)
+	}
+}
+
+func TestHooksPostFetch(t *testing.T) {
+	startTestEnv(t)
+	defer stopTestEnv(t)
+
+	ts := topicServer(nil)
+	defer ts.Close()
+	config = &configuration{Server: ts.URL, Token: "1234"}
+
+	// Failures of "post-*" hooks are only reported.
+	writeHook(t, postFetch, 1, 0755)
+	var err error
+	res := capture.All(func() { err = fetch() })
+	errCheck(t, err)
+	if !strings.Contains(string(res.Stdout), "warning") {
+		t.Fatalf("Expected a warning: %v", string(res.Stdout))
+	}
+	event := readEvent(t, postFetch)
+	if event == nil || len(event.Topics) != 2 || event.Topics[1].Name != "topic2" || event.Topics[1].Path == "" {
+		t.Fatalf("Unexpected event: %+v", event)
+	}
+
+	// Non-executable hooks are ignored.
+	errCheck(t, os.Remove(dataPath(postFetch+".json")))
+	writeHook(t, postFetch, 0, 0644)
+	_ = capture.All(func() { errCheck(t, fetch()) })
+	if event := readEvent(t, postFetch); event != nil {
+		t.Fatalf("The hook should not have been run: %+v", event)
+	}
+}
@@ -0,0 +1,347 @@
+// Copyright (C) 2014-2017 Miquel Sabaté Solà <mikisabate@gmail.com>
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package lib
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"html/template"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+var (
+	// Matches the links inside of HTML contents, so the ones pointing to
+	// other topics can be rewritten.
+	hrefRegexp = regexp.MustCompile(`href="([^"]*)"`)
+
+	// The layout shared by all the pages of an exported site.
+	htmlLayout = template.Must(template.New("layout").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+<style>
+body { max-width: 50em; margin: 2em auto; padding: 0 1em; font-family: sans-serif; line-height: 1.5; }
+pre { background: #f4f4f4; padding: 0.5em; overflow-x: auto; }
+blockquote { border-left: 3px solid #ccc; margin-left: 0; padding-left: 1em; color: #555; }
+nav { border-bottom: 1px solid #ccc; margin-bottom: 1em; }
+.created { color: #777; font-size: 0.9em; }
+</style>
+</head>
+<body>
+{{if .Index}}<nav><a href="{{.Index}}">Index</a></nav>
+{{end}}{{.Body}}
+</body>
+</html>
+`))
+
+	// The list of topics shown on the index of an exported site.
+	htmlIndex = template.Must(template.New("index").Parse(`<h1>Topics</h1>
+<ul>
+{{range .}}<li><a href="{{.Link}}">{{.Name}}</a> <span class="created">{{.Created}}</span></li>
+{{end}}</ul>
+`))
+)
+
+// htmlEntry is a topic as shown on the index of an exported site.
+type htmlEntry struct {
+	Name    string
+	Link    string
+	Created string
+}
+
+// markdownHTML renders the given markdown contents into HTML. As with the
+// terminal renderer (see renderMarkdown), this just handles the elements that
+// are commonly used on topics.
+func markdownHTML(contents string) string {
+	r := &renderer{html: true}
+	var b bytes.Buffer
+	var paragraph, quote []string
+	var lists []htmlList
+	fence := ""
+
+	flush := func() {
+		if len(paragraph) > 0 {
+			fmt.Fprintf(&b, "<p>%v</p>\n", r.inline(strings.Join(paragraph, " ")))
+			paragraph = nil
+		}
+		if len(quote) > 0 {
+			fmt.Fprintf(&b, "<blockquote><p>%v</p></blockquote>\n", r.inline(strings.Join(quote, " ")))
+			quote = nil
+		}
+	}
+	closeLists := func(indent int) {
+		for len(lists) > 0 && lists[len(lists)-1].indent > indent {
+			fmt.Fprintf(&b, "</li></%v>\n", lists[len(lists)-1].tag)
+			lists = lists[:len(lists)-1]
+		}
+	}
+
+	for _, line := range strings.Split(strings.TrimRight(contents, "\n"), "\n") {
+		trimmed := strings.TrimSpace(line)
+
+		if fence != "" {
+			if strings.HasPrefix(trimmed, fence) {
+				b.WriteString("</code></pre>\n")
+				fence = ""
+			} else {
+				b.WriteString(html.EscapeString(line) + "\n")
+			}
+			continue
+		}
+
+		if m := listItem.FindStringSubmatch(line); m != nil && !hrule.MatchString(line) {
+			flush()
+			indent := len(m[1])
+			tag := "ul"
+			if m[2][0] >= '0' && m[2][0] <= '9' {
+				tag = "ol"
+			}
+			closeLists(indent)
+			if n := len(lists); n > 0 && lists[n-1].indent == indent && lists[n-1].tag != tag {
+				closeLists(indent - 1)
+			}
+			if n := len(lists); n > 0 && lists[n-1].indent == indent {
+				b.WriteString("</li>\n")
+			} else {
+				fmt.Fprintf(&b, "<%v>\n", tag)
+				lists = append(lists, htmlList{indent: indent, tag: tag})
+			}
+
+			text := m[3]
+			b.WriteString("<li>")
+			if c := checkbox.FindStringSubmatch(text); c != nil {
+				text = text[len(c[0]):]
+				if c[1] == " " {
+					b.WriteString(`<input type="checkbox" disabled> `)
+				} else {
+					b.WriteString(`<input type="checkbox" disabled checked> `)
+				}
+			}
+			b.WriteString(r.inline(text))
+			continue
+		}
+
+		switch {
+		case trimmed == "":
+			flush()
+			continue
+		case len(lists) > 0 && line != trimmed:
+			// Continuation of the current item of a list.
+			b.WriteString(" " + r.inline(trimmed))
+			continue
+		}
+
+		closeLists(-1)
+		switch {
+		case strings.HasPrefix(trimmed, "```") || strings.HasPrefix(trimmed, "~~~"):
+			flush()
+			fence = trimmed[:3]
+			if lang := strings.TrimSpace(trimmed[3:]); lang != "" {
+				fmt.Fprintf(&b, "<pre><code class=\"language-%v\">", html.EscapeString(lang))
+			} else {
+				b.WriteString("<pre><code>")
+			}
+		case strings.HasPrefix(trimmed, "#") && heading(trimmed) > 0:
+			flush()
+			level := heading(trimmed)
+			text := strings.TrimSpace(strings.Trim(trimmed, "#"))
+			fmt.Fprintf(&b, "<h%v>%v</h%v>\n", level, r.inline(text), level)
+		case hrule.MatchString(line):
+			flush()
+			b.WriteString("<hr>\n")
+		case strings.HasPrefix(trimmed, ">"):
+			if len(paragraph) > 0 {
+				flush()
+			}
+			quote = append(quote, strings.TrimSpace(strings.TrimPrefix(trimmed, ">")))
+		default:
+			if len(quote) > 0 {
+				flush()
+			}
+			paragraph = append(paragraph, trimmed)
+		}
+	}
+
+	flush()
+	closeLists(-1)
+	if fence != "" {
+		b.WriteString("</code></pre>\n")
+	}
+	return b.String()
+}
+
+// htmlList is a list being rendered by markdownHTML.
+type htmlList struct {
+	indent int
+	tag    string
+}
+
+// topicHTML returns the HTML body of the given topic. The HTML rendered by
+// the server is used if available, unless the builtin flag is set to true.
+func topicHTML(t *Topic, builtin bool) string {
+	if !builtin && t.Markdown != "" {
+		return t.Markdown
+	}
+	return markdownHTML(t.Contents)
+}
+
+// crossLinks rewrites the links from the given HTML contents that point to
+// the name of a topic (or to its file), by using the given function to get
+// the new target.
+func crossLinks(contents string, topics []Topic, target func(t *Topic) string) string {
+	return hrefRegexp.ReplaceAllStringFunc(contents, func(attr string) string {
+		href := html.UnescapeString(hrefRegexp.FindStringSubmatch(attr)[1])
+		if unescaped, err := url.PathUnescape(href); err == nil {
+			href = unescaped
+		}
+		for k, t := range topics {
+			if href == t.Name || href == t.Name+topicExt || href == filepath.ToSlash(topicFile(t.Name)) {
+				return fmt.Sprintf(`href="%v"`, html.EscapeString(target(&topics[k])))
+			}
+		}
+		return attr
+	})
+}
+
+// pageFile returns the path, relative to the exported site, of the page of
+// the topic with the given name.
+func pageFile(name string) string {
+	return strings.TrimSuffix(topicFile(name), topicExt) + ".html"
+}
+
+// relativeURL returns the URL of the given page relative to the given one.
+// Both pages are relative to the root of the exported site.
+func relativeURL(from, to string) string {
+	rel, err := filepath.Rel(filepath.Dir(from), to)
+	if err != nil {
+		rel = to
+	}
+	segments := strings.Split(filepath.ToSlash(rel), "/")
+	for k, s := range segments {
+		if s != ".." {
+			segments[k] = url.PathEscape(s)
+		}
+	}
+	return strings.Join(segments, "/")
+}
+
+// anchor returns the identifier of the section of the given topic inside of
+// a single-file export.
+func anchor(t *Topic) string {
+	if t.ID != "" {
+		return "topic-" + t.ID
+	}
+	return "topic-" + url.PathEscape(t.Name)
+}
+
+// layout returns a whole HTML document with the given title and body. If
+// index is not empty, then a link to it is shown on top.
+func layout(title, index, body string) ([]byte, error) {
+	var b bytes.Buffer
+	err := htmlLayout.Execute(&b, map[string]interface{}{
+		"Title": title,
+		"Index": index,
+		"Body":  template.HTML(body),
+	})
+	return b.Bytes(), err
+}
+
+// index returns the HTML listing the given topics, by using the given function
+// to get the link of each of them.
+func index(topics []Topic, link func(t *Topic) string) (string, error) {
+	var entries []htmlEntry
+	for k, t := range topics {
+		e := htmlEntry{Name: t.Name, Link: link(&topics[k])}
+		if !t.CreatedAt.IsZero() {
+			e.Created = t.CreatedAt.Format("2006-01-02")
+		}
+		entries = append(entries, e)
+	}
+
+	var b bytes.Buffer
+	err := htmlIndex.Execute(&b, entries)
+	return b.String(), err
+}
+
+// exportHTML writes a static site with the given topics into the given
+// directory: a page for each topic and an "index.html" page listing them in
+// the given order. If bundle is true, then everything is written instead into
+// a single HTML file with the given path.
+func exportHTML(topics []Topic, path string, bundle, builtin bool) error {
+	if bundle {
+		return exportBundle(topics, path, builtin)
+	}
+
+	for k, t := range topics {
+		page := pageFile(t.Name)
+		body := crossLinks(topicHTML(&topics[k], builtin), topics, func(o *Topic) string {
+			return relativeURL(page, pageFile(o.Name))
+		})
+		body = fmt.Sprintf("<h1>%v</h1>\n%v", html.EscapeString(t.Name), body)
+		contents, err := layout(t.Name, relativeURL(page, "index.html"), body)
+		if err != nil {
+			return err
+		}
+
+		file := filepath.Join(path, page)
+		if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+			return err
+		}
+		if err := writeFile(file, contents, 0644); err != nil {
+			return err
+		}
+	}
+
+	body, err := index(topics, func(t *Topic) string {
+		return relativeURL("index.html", pageFile(t.Name))
+	})
+	if err != nil {
+		return err
+	}
+	contents, err := layout("Topics", "", body)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(path, 0755); err != nil {
+		return err
+	}
+	return writeFile(filepath.Join(path, "index.html"), contents, 0644)
+}
+
+// exportBundle writes the given topics into a single HTML file with the
+// given path. Each topic gets its own section, and links between topics point
+// to these sections.
+func exportBundle(topics []Topic, path string, builtin bool) error {
+	link := func(t *Topic) string { return "#" + anchor(t) }
+
+	body, err := index(topics, link)
+	if err != nil {
+		return err
+	}
+	for k, t := range topics {
+		contents := crossLinks(topicHTML(&topics[k], builtin), topics, link)
+		body += fmt.Sprintf("<section id=\"%v\">\n<h1>%v</h1>\n%v</section>\n",
+			html.EscapeString(anchor(&t)), html.EscapeString(t.Name), contents)
+	}
+
+	contents, err := layout("Topics", "", body)
+	if err != nil {
+		return err
+	}
+	if dir := filepath.Dir(path); dir != "" {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+	}
+	return writeFile(path, contents, 0644)
+}
@@ -0,0 +1,65 @@
+// Copyright (C) 2014-2017 Miquel Sabaté Solà <mikisabate@gmail.com>
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package lib
+
+import "testing"
+
+func TestMarkdownHTML(t *testing.T) {
+	tests := []struct {
+		markdown, expected string
+	}{
+		{"# Title", "<h1>Title</h1>\n"},
+		{"some *text*\nand <more>\n\nother", "<p>some <em>text</em> and &lt;more&gt;</p>\n<p>other</p>\n"},
+		{"See [it](work/backend).", "<p>See <a href=\"work/backend\">it</a>.</p>\n"},
+		{"[a](https://a.b) [b](mailto:me@a.b)", "<p><a href=\"https://a.b\">a</a> <a href=\"mailto:me@a.b\">b</a></p>\n"},
+		{"[x](javascript:alert(1))", "<p>x (javascript:alert(1))</p>\n"},
+		{"[x](JavaScript:alert(1)) [y](data:text/html,<b>)", "<p>x (JavaScript:alert(1)) y (data:text/html,&lt;b&gt;)</p>\n"},
+		{
+			"- one\n  - two\n    more\n- [x] three\n1. four",
+			"<ul>\n<li>one<ul>\n<li>two more</li></ul>\n</li>\n" +
+				"<li><input type=\"checkbox\" disabled checked> three</li></ul>\n" +
+				"<ol>\n<li>four</li></ol>\n",
+		},
+		{"```go\na < b\n```", "<pre><code class=\"language-go\">a &lt; b\n</code></pre>\n"},
+		{"> quoted\n> text\n\n---", "<blockquote><p>quoted text</p></blockquote>\n<hr>\n"},
+	}
+
+	for _, test := range tests {
+		if h := markdownHTML(test.markdown); h != test.expected {
+			t.Fatalf("Rendering %q: expected %q, got %q", test.markdown, test.expected, h)
+		}
+	}
+}
+
+func TestCrossLinks(t *testing.T) {
+	topics := []Topic{{ID: "1", Name: "work/backend"}, {ID: "2", Name: "100%"}}
+	target := func(t *Topic) string { return "#" + anchor(t) }
+
+	contents := `<a href="work/backend">a</a> <a href="100%25.md">b</a> <a href="http://a.b">c</a>`
+	expected := `<a href="#topic-1">a</a> <a href="#topic-2">b</a> <a href="http://a.b">c</a>`
+	if c := crossLinks(contents, topics, target); c != expected {
+		t.Fatalf("Expected %q, got %q", expected, c)
+	}
+}
+
+func TestRelativeURL(t *testing.T) {
+	tests := []struct {
+		from, to, expected string
+	}{
+		{"index.html", "topic.html", "topic.html"},
+		{"index.html", "work/backend.html", "work/backend.html"},
+		{"work/backend.html", "index.html", "../index.html"},
+		{"work/backend.html", "100%25.html", "../100%2525.html"},
+		{"a/b.html", "a/c d.html", "c%20d.html"},
+	}
+
+	for _, test := range tests {
+		if u := relativeURL(test.from, test.to); u != test.expected {
+			t.Fatalf("From %v to %v: expected %v, got %v", test.from, test.to, test.expected, u)
+		}
+	}
+}
@@ -0,0 +1,203 @@
+// Copyright (C) 2014-2017 Miquel Sabaté Solà <mikisabate@gmail.com>
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package lib
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// ImportOptions contains the options of the `import` command.
+type ImportOptions struct {
+	// The format of the data to be imported: "json", "dir" or "tar". If
+	// empty, it's guessed from the given path.
+	Format string
+
+	// Only show what would be done, without changing anything (not even the
+	// cache).
+	DryRun bool
+
+	// Skip the topics that already exist on the server.
+	SkipExisting bool
+
+	// Replace the contents of the topics that already exist on the server.
+	Overwrite bool
+}
+
+// Import creates the topics from the given path, as written by the `export`
+// command. Topics are matched by name with the existing ones, and by default
+// nothing is imported if any of them already exists (see ImportOptions). The
+// path can be "-" for the "json" and the "tar" formats, and then the data is
+// read from the standard input.
+func Import(path string, opts *ImportOptions) error {
+	if opts.SkipExisting && opts.Overwrite {
+		return NewError("cannot skip and overwrite existing topics at the same time")
+	}
+
+	topics, err := importTopics(path, opts.Format)
+	if err != nil {
+		return err
+	}
+
+	// Dry runs do not change anything, not even the cache.
+	if !opts.DryRun {
+		unlock, err := lockCache()
+		if err != nil {
+			return err
+		}
+		defer unlock()
+	}
+
+	// Check first for the topics that already exist, so nothing is imported
+	// if we cannot import everything.
+	current, err := serverTopics()
+	if err != nil {
+		return See("could not fetch the topics: "+errorMessage(err), "edit")
+	}
+	existing := make(map[string]string)
+	for _, t := range current {
+		existing[t.Name] = t.ID
+	}
+	if !opts.SkipExisting && !opts.Overwrite {
+		var names []string
+		for _, t := range topics {
+			if _, ok := existing[t.Name]; ok {
+				names = append(names, t.Name)
+			}
+		}
+		if len(names) > 0 {
+			msg := fmt.Sprintf("the following topics already exist: %v. Use either "+
+				"--skip-existing or --overwrite", strings.Join(names, ", "))
+			return NewError(msg)
+		}
+	}
+
+	var fails []string
+	created, overwritten, skipped := 0, 0, 0
+	for _, t := range topics {
+		id, ok := existing[t.Name]
+		switch {
+		case ok && opts.SkipExisting:
+			fmt.Printf("Skipping '%v'.\n", t.Name)
+			skipped++
+			continue
+		case ok:
+			fmt.Printf("Overwriting '%v'.\n", t.Name)
+			overwritten++
+		default:
+			fmt.Printf("Creating '%v'.\n", t.Name)
+			created++
+		}
+		if opts.DryRun {
+			continue
+		}
+
+		if !ok {
+			nt, err := newTopic(t.Name, t.Contents)
+			if err != nil {
+				fails = append(fails, t.Name)
+			} else {
+				existing[t.Name] = nt.ID
+			}
+			continue
+		}
+		if t.Contents != "" {
+			if err := pushContents(id, t.Contents); err != nil {
+				fails = append(fails, t.Name)
+			}
+		}
+	}
+
+	verb := "Imported"
+	if opts.DryRun {
+		verb = "Would import"
+	}
+	fmt.Printf("%v %v topics: %v created, %v overwritten and %v skipped.\n",
+		verb, created+overwritten, created, overwritten, skipped)
+
+	// Refresh the cache with the imported topics.
+	if !opts.DryRun {
+		if err := fetch(); err != nil {
+			warning("could not fetch the imported topics: %v.", errorMessage(err))
+		}
+	}
+	if len(fails) > 0 {
+		return NewError("could not import: " + strings.Join(fails, ", "))
+	}
+	return nil
+}
+
+// importTopics reads the topics to be imported from the given path in the
+// given format. If the format is empty, then it's guessed from the path.
+func importTopics(path, format string) ([]Topic, error) {
+	if format == "" {
+		format = importFormat(path)
+	}
+
+	var topics []Topic
+	var entries map[string][]byte
+	var err error
+
+	switch format {
+	case "json":
+		var body []byte
+		if body, err = readInput(path); err == nil {
+			err = json.Unmarshal(body, &topics)
+		}
+	case "tar":
+		var body []byte
+		if body, err = readInput(path); err == nil {
+			gz := compressed(path) || bytes.HasPrefix(body, []byte{0x1f, 0x8b})
+			if entries, err = readTar(bytes.NewReader(body), gz); err == nil {
+				topics, err = entriesTopics(entries)
+			}
+		}
+	case "dir":
+		if entries, err = readEntries(path); err == nil {
+			topics, err = entriesTopics(entries)
+		}
+	default:
+		return nil, NewError(fmt.Sprintf("unknown format '%v'", format))
+	}
+	if err != nil {
+		return nil, NewError(fmt.Sprintf("could not read '%v': %v", path, err))
+	}
+
+	for _, t := range topics {
+		if t.Name == "" {
+			return nil, NewError(fmt.Sprintf("'%v' contains topics without a name", path))
+		}
+	}
+	return topics, nil
+}
+
+// importFormat guesses the format of the data to be imported from the given
+// path.
+func importFormat(path string) string {
+	if fi, err := os.Stat(path); err == nil && fi.IsDir() {
+		return "dir"
+	}
+	for _, ext := range []string{".tar", ".tar.gz", ".tgz"} {
+		if strings.HasSuffix(path, ext) {
+			return "tar"
+		}
+	}
+	return "json"
+}
+
+// readInput returns the contents of the file with the given path, or of the
+// standard input if the path is "-".
+func readInput(path string) ([]byte, error) {
+	if path == "-" {
+		return ioutil.ReadAll(os.Stdin)
+	}
+	return ioutil.ReadFile(path)
+}
@@ -0,0 +1,129 @@
+// Copyright (C) 2014-2017 Miquel Sabaté Solà <mikisabate@gmail.com>
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package lib
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mssola/capture"
+)
+
+// importFile writes the given topics as JSON into a temporary file.
+func importFile(t *testing.T, topics []Topic) string {
+	f, err := ioutil.TempFile("", "td-import")
+	errCheck(t, err)
+	body, _ := json.Marshal(topics)
+	_, err = f.Write(body)
+	errCheck(t, err)
+	errCheck(t, f.Close())
+	return f.Name()
+}
+
+func TestImport(t *testing.T) {
+	startTestEnv(t)
+	defer stopTestEnv(t)
+
+	ts := topicServer(nil)
+	defer ts.Close()
+	config = &configuration{Server: ts.URL, Token: "1234"}
+
+	file := importFile(t, []Topic{
+		{ID: "10", Name: "topic1", Contents: "imported"},
+		{ID: "11", Name: "topic3", Contents: "three"},
+	})
+	defer func() { _ = os.Remove(file) }()
+
+	// Existing topics are not touched by default.
+	var err error
+	capture.All(func() { err = Import(file, &ImportOptions{}) })
+	if err == nil || !strings.Contains(err.Error(), "the following topics already exist: topic1") {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(testTopics) != 2 {
+		t.Fatalf("Nothing should have been imported: %v", testTopics)
+	}
+	capture.All(func() { err = Import(file, &ImportOptions{SkipExisting: true, Overwrite: true}) })
+	if err == nil {
+		t.Fatalf("Expecting an error")
+	}
+
+	// Dry runs.
+	res := capture.All(func() { err = Import(file, &ImportOptions{DryRun: true, Overwrite: true}) })
+	if err != nil {
+		t.Fatalf("Not expecting error: %v", err)
+	}
+	if !strings.Contains(string(res.Stdout), "Would import 2 topics: 1 created, 1 overwritten and 0 skipped.") {
+		t.Fatalf("Unexpected output: %s", res.Stdout)
+	}
+	if len(testTopics) != 2 || testTopics[0].Contents != "1111" {
+		t.Fatalf("Nothing should have been imported: %v", testTopics)
+	}
+	if _, err = os.Stat(filepath.Join(home(), dirName, topicsName)); !os.IsNotExist(err) {
+		t.Fatalf("The cache should not have been touched: %v", err)
+	}
+
+	// Skipping existing topics. Created topics run the "post-create" hook.
+	writeHook(t, postCreate, 0, 0755)
+	capture.All(func() { err = Import(file, &ImportOptions{SkipExisting: true}) })
+	if err != nil {
+		t.Fatalf("Not expecting error: %v", err)
+	}
+	if len(testTopics) != 3 || testTopics[0].Contents != "1111" || testTopics[2].Contents != "three" {
+		t.Fatalf("Unexpected topics: %v", testTopics)
+	}
+	if c := readNew(t, "topic3.md"); c != "three" {
+		t.Fatalf("The cache should have been refreshed, got: %v", c)
+	}
+	if event := readEvent(t, postCreate); event == nil || len(event.Topics) != 1 || event.Topics[0].Name != "topic3" {
+		t.Fatalf("Unexpected event: %+v", event)
+	}
+
+	// Overwriting them.
+	capture.All(func() { err = Import(file, &ImportOptions{Overwrite: true}) })
+	if err != nil {
+		t.Fatalf("Not expecting error: %v", err)
+	}
+	if len(testTopics) != 3 || testTopics[0].Contents != "imported" {
+		t.Fatalf("Unexpected topics: %v", testTopics)
+	}
+}
+
+func TestImportFormats(t *testing.T) {
+	dir, err := ioutil.TempDir("", "td-import")
+	errCheck(t, err)
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	if f := importFormat(dir); f != "dir" {
+		t.Fatalf("Expecting 'dir', got: %v", f)
+	}
+	for path, format := range map[string]string{"a.tar": "tar", "a.tgz": "tar", "a.json": "json", "-": "json"} {
+		if f := importFormat(path); f != format {
+			t.Fatalf("Expecting '%v' for '%v', got: %v", format, path, f)
+		}
+	}
+
+	errCheck(t, writeEntries(dir, topicEntries(archiveTopics)))
+	topics, err := importTopics(dir, "")
+	if err != nil {
+		t.Fatalf("Not expecting error: %v", err)
+	}
+	compareTopics(t, topics, archiveTopics)
+
+	file := filepath.Join(dir, "bad.json")
+	errCheck(t, ioutil.WriteFile(file, []byte(`[{"contents": "no name"}]`), 0644))
+	if _, err = importTopics(file, ""); err == nil || !strings.Contains(err.Error(), "contains topics without a name") {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if _, err = importTopics(file, "zip"); err == nil || !strings.Contains(err.Error(), "unknown format 'zip'") {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+}
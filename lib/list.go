@@ -0,0 +1,184 @@
+// Copyright (C) 2014-2017 Miquel Sabaté Solà <mikisabate@gmail.com>
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package lib
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"text/tabwriter"
+	"text/template"
+	"time"
+)
+
+// ListOptions contains the options of the `list` command.
+type ListOptions struct {
+	// Show the full name of each topic instead of a tree of namespaces.
+	Flat bool
+
+	// Show the topics as JSON.
+	JSON bool
+
+	// Show the topics as a table with more information.
+	Long bool
+
+	// A text/template to be executed for each topic (see listedTopic).
+	Format string
+
+	// Only show the cached topics, without contacting the server.
+	Offline bool
+
+	// How topics are sorted: by "name", by "created" date or by "size". If
+	// empty, topics are shown in the order given by the server.
+	Sort string
+}
+
+// listedTopic is a topic as shown by the `list` command.
+type listedTopic struct {
+	Topic
+
+	// The size in bytes of the local contents.
+	Size int64 `json:"size"`
+
+	// Whether the topic has local changes that have not been pushed.
+	Modified bool `json:"modified"`
+
+	// The last time that the topics were fetched from the server.
+	FetchedAt time.Time `json:"fetched_at"`
+}
+
+// listTopics returns the cached topics sorted as given.
+func listTopics(sorting string) ([]listedTopic, error) {
+	var topics []Topic
+	var res []listedTopic
+
+	modified := make(map[string]bool)
+	for _, t := range changedTopics() {
+		modified[t.Name] = true
+	}
+
+	readTopics(&topics)
+	fetched := lastFetched()
+	dir := dataPath(newDir)
+	for _, t := range topics {
+		lt := listedTopic{Topic: t, Modified: modified[t.Name], FetchedAt: fetched}
+		if fi, err := os.Stat(topicPath(dir, t.Name)); err == nil {
+			lt.Size = fi.Size()
+		}
+		res = append(res, lt)
+	}
+
+	var less func(a, b *listedTopic) bool
+	switch sorting {
+	case "":
+		return res, nil
+	case "name":
+		less = func(a, b *listedTopic) bool { return a.Name < b.Name }
+	case "created":
+		less = func(a, b *listedTopic) bool { return a.CreatedAt.Before(b.CreatedAt) }
+	case "size":
+		less = func(a, b *listedTopic) bool { return a.Size < b.Size }
+	default:
+		return nil, NewError(fmt.Sprintf("unknown sorting '%v'", sorting))
+	}
+	sort.SliceStable(res, func(i, j int) bool { return less(&res[i], &res[j]) })
+	return res, nil
+}
+
+// useDefault sets the output of the list command from the given value of the
+// "list_format" setting.
+func (opts *ListOptions) useDefault(format string) {
+	switch format {
+	case "tree":
+	case "flat":
+		opts.Flat = true
+	case "long":
+		opts.Long = true
+	case "json":
+		opts.JSON = true
+	default:
+		opts.Format = format
+	}
+}
+
+// names returns true if only the names of the topics are requested in the
+// order in which they are cached. In this case the list of topics is enough,
+// and there's no need to look at the cached files (see listNames).
+func (opts *ListOptions) names() bool {
+	return opts.Flat && !opts.JSON && !opts.Long && opts.Format == "" && opts.Sort == ""
+}
+
+// listNames prints the name of each cached topic as stored in the list of
+// topics. It's meant to be fast, since it's used by shell completion.
+func listNames() {
+	var topics []Topic
+
+	readTopics(&topics)
+	for _, t := range topics {
+		fmt.Printf("%v\n", t.Name)
+	}
+}
+
+// printTopics prints the given topics as requested by the given options.
+func printTopics(topics []listedTopic, opts *ListOptions) error {
+	switch {
+	case opts.JSON:
+		if topics == nil {
+			topics = []listedTopic{}
+		}
+		body, _ := json.MarshalIndent(topics, "", "  ")
+		fmt.Printf("%s\n", body)
+	case opts.Format != "":
+		tmpl, err := template.New("format").Parse(opts.Format)
+		if err != nil {
+			return NewError(fmt.Sprintf("bad format: %v", err))
+		}
+		for _, t := range topics {
+			if err := tmpl.Execute(os.Stdout, &t); err != nil {
+				return NewError(fmt.Sprintf("bad format: %v", err))
+			}
+			fmt.Println()
+		}
+	case opts.Long:
+		printLong(topics)
+	case opts.Flat:
+		for _, t := range topics {
+			fmt.Printf("%v\n", t.Name)
+		}
+	default:
+		var names []string
+		for _, t := range topics {
+			names = append(names, t.Name)
+		}
+		printTree(newTree(names), "")
+	}
+	return nil
+}
+
+// printLong prints the given topics as a table.
+func printLong(topics []listedTopic) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	_, _ = fmt.Fprintln(w, "ID\tCREATED\tSIZE\tSTATE\tNAME")
+	for _, t := range topics {
+		created, state := "-", "-"
+		if !t.CreatedAt.IsZero() {
+			created = t.CreatedAt.Local().Format("2006-01-02 15:04")
+		}
+		if t.Modified {
+			state = "modified"
+		}
+		_, _ = fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\n", t.ID, created, t.Size, state, t.Name)
+	}
+	_ = w.Flush()
+
+	fetched := "unknown"
+	if t := lastFetched(); !t.IsZero() {
+		fetched = t.Local().Format("2006-01-02 15:04:05")
+	}
+	fmt.Printf("\nLast fetched: %v\n", fetched)
+}
@@ -0,0 +1,157 @@
+// Copyright (C) 2014-2017 Miquel Sabaté Solà <mikisabate@gmail.com>
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package lib
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mssola/capture"
+)
+
+func listOutput(t *testing.T, opts *ListOptions) string {
+	var err error
+	res := capture.All(func() { err = List(opts) })
+	errCheck(t, err)
+	return string(res.Stdout)
+}
+
+func TestListJSON(t *testing.T) {
+	defer exportServer(t)()
+
+	var topics []listedTopic
+	out := listOutput(t, &ListOptions{JSON: true, Sort: "name"})
+	if err := json.Unmarshal([]byte(out), &topics); err != nil {
+		t.Fatalf("Could not parse the output: %v\n%v", err, out)
+	}
+	if len(topics) != 2 {
+		t.Fatalf("Expected two topics, got: %v", topics)
+	}
+	if topics[0].Name != "home" || topics[0].ID != "2" || topics[0].Size != 6 {
+		t.Fatalf("Unexpected topic: %+v", topics[0])
+	}
+	if topics[1].Name != "work/backend" || topics[1].Modified || topics[1].FetchedAt.IsZero() {
+		t.Fatalf("Unexpected topic: %+v", topics[1])
+	}
+}
+
+func TestListFormat(t *testing.T) {
+	defer exportServer(t)()
+
+	out := listOutput(t, &ListOptions{Format: "{{.ID}} {{.Name}}", Sort: "created"})
+	compareSlices(t, strings.Split(strings.TrimSpace(out), "\n"), []string{"2 home", "1 work/backend"})
+
+	var err error
+	_ = capture.All(func() { err = List(&ListOptions{Format: "{{.Nope}}"}) })
+	if err == nil || !strings.Contains(err.Error(), "bad format") {
+		t.Fatalf("Expected a bad format error, got: %v", err)
+	}
+}
+
+func TestListLong(t *testing.T) {
+	defer exportServer(t)()
+
+	// Fetch the topics and modify one of them locally.
+	_ = capture.All(func() { errCheck(t, List(&ListOptions{})) })
+	writeIn(t, filepath.Join(home(), dirName, newDir), "home.md", "# Home, changed locally")
+
+	out := listOutput(t, &ListOptions{Long: true, Sort: "size"})
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	if len(lines) != 5 {
+		t.Fatalf("Unexpected output:\n%v", out)
+	}
+	if !strings.HasPrefix(lines[0], "ID") || !strings.Contains(lines[0], "STATE") {
+		t.Fatalf("Unexpected header: %v", lines[0])
+	}
+	if !strings.HasPrefix(lines[1], "1 ") || strings.Contains(lines[1], "modified") ||
+		!strings.Contains(lines[1], " 16 ") {
+		t.Fatalf("Unexpected line: %v", lines[1])
+	}
+	if !strings.HasPrefix(lines[2], "2 ") || !strings.Contains(lines[2], "modified") ||
+		!strings.Contains(lines[2], " 23 ") {
+		t.Fatalf("Unexpected line: %v", lines[2])
+	}
+	if !strings.HasPrefix(lines[4], "Last fetched: 20") {
+		t.Fatalf("Unexpected line: %v", lines[4])
+	}
+}
+
+func TestListUnknownSorting(t *testing.T) {
+	defer exportServer(t)()
+
+	var err error
+	_ = capture.All(func() { err = List(&ListOptions{Sort: "whatever"}) })
+	if err == nil || !strings.Contains(err.Error(), "unknown sorting 'whatever'") {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+}
+
+func TestLastFetched(t *testing.T) {
+	startTestEnv(t)
+	defer stopTestEnv(t)
+
+	if !lastFetched().IsZero() {
+		t.Fatal("Topics have never been fetched")
+	}
+	errCheck(t, ioutil.WriteFile(filepath.Join(home(), dirName, fetchedName), []byte("garbage"), 0644))
+	if !lastFetched().IsZero() {
+		t.Fatal("Malformed timestamps should be ignored")
+	}
+}
+
+func TestListOffline(t *testing.T) {
+	defer exportServer(t)()
+
+	_ = listOutput(t, &ListOptions{})
+	testTopics = append(testTopics, Topic{ID: "3", Name: "new"})
+
+	// Both the fast path and the regular one only use the cache.
+	out := listOutput(t, &ListOptions{Flat: true, Offline: true})
+	compareSlices(t, strings.Split(strings.TrimSpace(out), "\n"), []string{"work/backend", "home"})
+	out = listOutput(t, &ListOptions{Format: "{{.Name}}", Sort: "name", Offline: true})
+	compareSlices(t, strings.Split(strings.TrimSpace(out), "\n"), []string{"home", "work/backend"})
+
+	out = listOutput(t, &ListOptions{Flat: true})
+	compareSlices(t, strings.Split(strings.TrimSpace(out), "\n"),
+		[]string{"Fetching the topics from the server.", "work/backend", "home", "new"})
+}
+
+func TestListCacheTTL(t *testing.T) {
+	defer exportServer(t)()
+
+	config.CacheTTL = "1h"
+	_ = listOutput(t, &ListOptions{})
+	testTopics = append(testTopics, Topic{ID: "3", Name: "new"})
+
+	// The cache is fresh, so the new topic is not fetched.
+	out := listOutput(t, &ListOptions{Flat: true})
+	compareSlices(t, strings.Split(strings.TrimSpace(out), "\n"), []string{"work/backend", "home"})
+
+	// The cache is stale if it was fetched before the TTL.
+	old := time.Now().Add(-2 * time.Hour).Format(time.RFC3339)
+	errCheck(t, ioutil.WriteFile(filepath.Join(home(), dirName, fetchedName), []byte(old), 0644))
+	out = listOutput(t, &ListOptions{Flat: true})
+	compareSlices(t, strings.Split(strings.TrimSpace(out), "\n"),
+		[]string{"Fetching the topics from the server.", "work/backend", "home", "new"})
+}
+
+func TestListFormatSetting(t *testing.T) {
+	defer exportServer(t)()
+
+	config.ListFormat = "{{.ID}}:{{.Name}}"
+	out := listOutput(t, &ListOptions{Sort: "name"})
+	compareSlices(t, strings.Split(strings.TrimSpace(out), "\n"), []string{"2:home", "1:work/backend"})
+
+	// Flags take precedence.
+	config.ListFormat = "json"
+	out = listOutput(t, &ListOptions{Flat: true, Offline: true})
+	compareSlices(t, strings.Split(strings.TrimSpace(out), "\n"), []string{"work/backend", "home"})
+}
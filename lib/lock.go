@@ -0,0 +1,135 @@
+// Copyright (C) 2014-2017 Miquel Sabaté Solà <mikisabate@gmail.com>
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package lib
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+)
+
+const (
+	// The name of the file that locks the cache while a command mutates it.
+	lockName = "lock"
+)
+
+// lockInfo contains the information stored inside of the lock file, so other
+// processes can tell who is holding the lock.
+type lockInfo struct {
+	PID       int       `json:"pid"`
+	Host      string    `json:"host"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// lockPath returns the path of the lock file.
+func lockPath() string {
+	return dataPath(lockName)
+}
+
+// hostname returns the name of the current host, or "unknown" if it cannot be
+// fetched.
+func hostname() string {
+	name, err := os.Hostname()
+	if err != nil {
+		return "unknown"
+	}
+	return name
+}
+
+// stale returns true if the process that created the given lock is gone. Note
+// that we can only check this for processes running on the current host, so
+// locks from other hosts are never considered stale.
+func (l *lockInfo) stale() bool {
+	if l.Host != hostname() || l.PID <= 0 {
+		return false
+	}
+	err := syscall.Kill(l.PID, 0)
+	return err == syscall.ESRCH
+}
+
+// createLock creates the lock file with the given contents. The file is first
+// written somewhere else and then hard-linked into place, so the creation
+// fails if the lock already exists, and other processes never see a lock file
+// with partial contents.
+func createLock(path string, body []byte) error {
+	f, err := ioutil.TempFile(filepath.Dir(path), "."+lockName+".")
+	if err != nil {
+		return err
+	}
+	defer func() { _ = os.Remove(f.Name()) }()
+
+	_, err = f.Write(body)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Link(f.Name(), path)
+}
+
+// removeStaleLock removes the lock with the given path if it still has the
+// given contents, which were found to be stale. Other processes might have
+// found it to be stale too, so the lock is first renamed to a unique name and
+// then its contents are checked again: if another process has replaced it with
+// a fresh lock meanwhile, then this one is put back in place.
+func removeStaleLock(path string, contents []byte) {
+	aside := fmt.Sprintf("%v.stale.%v.%v", path, os.Getpid(), time.Now().UnixNano())
+	if err := os.Rename(path, aside); err != nil {
+		return
+	}
+	if current, _ := ioutil.ReadFile(aside); !bytes.Equal(current, contents) {
+		_ = os.Link(aside, path)
+	}
+	_ = os.Remove(aside)
+}
+
+// lockCache acquires the advisory lock of the cache. It returns a function
+// that releases the lock, or an error explaining which process is holding it.
+// Stale locks (e.g. from processes that got killed) are removed silently.
+func lockCache() (func(), error) {
+	path := lockPath()
+	info := &lockInfo{PID: os.Getpid(), Host: hostname(), CreatedAt: time.Now()}
+	body, _ := json.Marshal(info)
+
+	for attempt := 0; attempt < 2; attempt++ {
+		err := createLock(path, body)
+		if err == nil {
+			return func() {
+				// Only remove the lock if it's still ours.
+				if current, _ := ioutil.ReadFile(path); bytes.Equal(current, body) {
+					_ = os.Remove(path)
+				}
+			}, nil
+		}
+		if !os.IsExist(err) {
+			// Nothing to lock if the cache does not exist: there is nothing
+			// that other processes could be mutating.
+			if os.IsNotExist(err) {
+				return func() {}, nil
+			}
+			return nil, fromError(err)
+		}
+
+		// Somebody else holds the lock: check whether it's stale or not.
+		var holder lockInfo
+		contents, _ := ioutil.ReadFile(path)
+		if err := json.Unmarshal(contents, &holder); err == nil && !holder.stale() {
+			msg := fmt.Sprintf("the cache is being used by another td process "+
+				"(PID %v on '%v' since %v). If this is not the case, remove '%v'",
+				holder.PID, holder.Host, holder.CreatedAt.Format(time.Stamp), path)
+			return nil, NewError(msg)
+		}
+		removeStaleLock(path, contents)
+	}
+	return nil, NewError("could not acquire the lock of the cache")
+}
@@ -0,0 +1,130 @@
+// Copyright (C) 2014-2017 Miquel Sabaté Solà <mikisabate@gmail.com>
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package lib
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLockCache(t *testing.T) {
+	startTestEnv(t)
+	defer stopTestEnv(t)
+
+	unlock, err := lockCache()
+	errCheck(t, err)
+
+	// The lock contains the information of the current process.
+	var info lockInfo
+	body, err := ioutil.ReadFile(lockPath())
+	errCheck(t, err)
+	errCheck(t, json.Unmarshal(body, &info))
+	if info.PID != os.Getpid() {
+		t.Fatalf("Expected PID %v; got %v", os.Getpid(), info.PID)
+	}
+	if info.Host != hostname() {
+		t.Fatalf("Expected host %v; got %v", hostname(), info.Host)
+	}
+
+	// Nobody else can get it while we hold it.
+	if _, err = lockCache(); err == nil {
+		t.Fatalf("We were expecting an error")
+	}
+	if !strings.Contains(err.Error(), "the cache is being used by another td process") {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// And it can be acquired again after releasing it.
+	unlock()
+	unlock, err = lockCache()
+	errCheck(t, err)
+	unlock()
+	if _, err = os.Stat(lockPath()); !os.IsNotExist(err) {
+		t.Fatalf("The lock should have been removed: %v", err)
+	}
+}
+
+func TestStaleLock(t *testing.T) {
+	startTestEnv(t)
+	defer stopTestEnv(t)
+
+	// A lock from a process that does not exist anymore.
+	info := &lockInfo{PID: 1 << 30, Host: hostname(), CreatedAt: time.Now()}
+	body, _ := json.Marshal(info)
+	errCheck(t, ioutil.WriteFile(lockPath(), body, 0644))
+
+	unlock, err := lockCache()
+	errCheck(t, err)
+	unlock()
+
+	// A lock from another host is respected.
+	info.Host = hostname() + "-other"
+	body, _ = json.Marshal(info)
+	errCheck(t, ioutil.WriteFile(lockPath(), body, 0644))
+	if _, err = lockCache(); err == nil {
+		t.Fatalf("We were expecting an error")
+	}
+
+	// Garbage is considered stale.
+	errCheck(t, ioutil.WriteFile(lockPath(), []byte("garbage"), 0644))
+	unlock, err = lockCache()
+	errCheck(t, err)
+	unlock()
+}
+
+func TestLockedCommands(t *testing.T) {
+	startTestEnv(t)
+	defer stopTestEnv(t)
+
+	ts := topicServer(nil)
+	defer ts.Close()
+	config = &configuration{Server: ts.URL, Token: "1234"}
+
+	unlock, err := lockCache()
+	errCheck(t, err)
+	defer unlock()
+
+	if err = Create("topic3"); err == nil {
+		t.Fatalf("We were expecting an error")
+	}
+	if len(testTopics) != 2 {
+		t.Fatalf("Expected 2 topics; got %v", len(testTopics))
+	}
+
+	// Listing just shows the cache.
+	testList(t, []string{""})
+}
+
+func TestRemoveStaleLock(t *testing.T) {
+	startTestEnv(t)
+	defer stopTestEnv(t)
+
+	// Another process has replaced the stale lock with a fresh one after we
+	// found it to be stale: the fresh one is kept.
+	errCheck(t, ioutil.WriteFile(lockPath(), []byte("fresh"), 0644))
+	removeStaleLock(lockPath(), []byte("stale"))
+	body, err := ioutil.ReadFile(lockPath())
+	errCheck(t, err)
+	if string(body) != "fresh" {
+		t.Fatalf("Expected the fresh lock to be kept; got: %v", string(body))
+	}
+
+	// The stale lock is removed, and nothing is left behind.
+	removeStaleLock(lockPath(), []byte("fresh"))
+	files, err := ioutil.ReadDir(filepath.Dir(lockPath()))
+	errCheck(t, err)
+	for _, fi := range files {
+		if strings.HasPrefix(fi.Name(), lockName) {
+			t.Fatalf("Unexpected file: %v", fi.Name())
+		}
+	}
+}
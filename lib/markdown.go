@@ -0,0 +1,274 @@
+// Copyright (C) 2014-2017 Miquel Sabaté Solà <mikisabate@gmail.com>
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package lib
+
+import (
+	"fmt"
+	"html"
+	"net/url"
+	"regexp"
+	"strings"
+	"unicode"
+
+	"github.com/mssola/colors"
+)
+
+var (
+	// Matches the items of lists. The first group is the indentation, the
+	// second one is the marker and the last one is the text of the item.
+	listItem = regexp.MustCompile(`^(\s*)([-*+]|\d+[.)])\s+(.*)$`)
+
+	// Matches the checkbox at the beginning of the text of a list item.
+	checkbox = regexp.MustCompile(`^\[([ xX])\]\s+`)
+
+	// Matches horizontal rules.
+	hrule = regexp.MustCompile(`^\s*([-*_])(\s*([-*_])){2,}\s*$`)
+)
+
+// renderer renders markdown into text suitable for the terminal. If color is
+// false, then the markup is removed but no escape sequences are emitted. If
+// html is true, then inline elements are rendered as HTML instead (see
+// markdownHTML).
+type renderer struct {
+	color bool
+	html  bool
+}
+
+// renderMarkdown returns the given markdown contents rendered for the
+// terminal. Note that this is not a full implementation of markdown: it just
+// handles the elements that are commonly used on topics (headings, emphasis,
+// lists, checkboxes, quotes, code blocks and links).
+func renderMarkdown(contents string, color bool) string {
+	r := &renderer{color: color}
+	var lines []string
+	fence := ""
+
+	for _, line := range strings.Split(strings.TrimRight(contents, "\n"), "\n") {
+		trimmed := strings.TrimSpace(line)
+
+		// Fenced code blocks are shown verbatim.
+		if fence != "" {
+			if strings.HasPrefix(trimmed, fence) {
+				fence = ""
+				continue
+			}
+			lines = append(lines, r.paint("    "+line, colors.Yellow, colors.Regular))
+			continue
+		}
+		if strings.HasPrefix(trimmed, "```") || strings.HasPrefix(trimmed, "~~~") {
+			fence = trimmed[:3]
+			continue
+		}
+
+		lines = append(lines, r.line(line))
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// line renders a single line that is not part of a code block.
+func (r *renderer) line(line string) string {
+	trimmed := strings.TrimSpace(line)
+
+	switch {
+	case strings.HasPrefix(trimmed, "#") && heading(trimmed) > 0:
+		text := strings.TrimSpace(strings.Trim(trimmed, "#"))
+		if heading(trimmed) == 1 {
+			return r.paint(r.inline(text), colors.Magenta, colors.Underlined)
+		}
+		return r.paint(r.inline(text), colors.Magenta, colors.Bold)
+	case hrule.MatchString(line):
+		return r.paint(strings.Repeat("─", 40), colors.Saved, colors.Regular)
+	case strings.HasPrefix(trimmed, ">"):
+		text := strings.TrimSpace(strings.TrimPrefix(trimmed, ">"))
+		return r.paint("│ ", colors.Cyan, colors.Regular) + r.inline(text)
+	}
+
+	if m := listItem.FindStringSubmatch(line); m != nil {
+		marker := "•"
+		if unicode.IsDigit(rune(m[2][0])) {
+			marker = m[2]
+		}
+		text := m[3]
+		if c := checkbox.FindStringSubmatch(text); c != nil {
+			text = text[len(c[0]):]
+			if c[1] == " " {
+				marker = r.paint("[ ]", colors.Red, colors.Bold)
+			} else {
+				marker = r.paint("[x]", colors.Green, colors.Bold)
+			}
+		}
+		return m[1] + marker + " " + r.inline(text)
+	}
+	return r.inline(line)
+}
+
+// heading returns the level of the heading on the given line, or zero if
+// it's not a heading.
+func heading(line string) int {
+	level := len(line) - len(strings.TrimLeft(line, "#"))
+	if level > 6 || (len(line) > level && line[level] != ' ') {
+		return 0
+	}
+	return level
+}
+
+// inline renders the inline elements of the given text: emphasis, code spans
+// and links.
+func (r *renderer) inline(text string) string {
+	var b strings.Builder
+
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+
+		switch {
+		case c == '\\' && i+1 < len(text) && strings.IndexByte("\\`*_[]()#+-.!", text[i+1]) >= 0:
+			b.WriteString(r.text(text[i+1 : i+2]))
+			i++
+			continue
+		case c == '`':
+			if end := strings.IndexByte(text[i+1:], '`'); end > 0 {
+				b.WriteString(r.code(text[i+1 : i+1+end]))
+				i += end + 1
+				continue
+			}
+		case c == '[':
+			if label, url, n := link(text[i:]); n > 0 {
+				b.WriteString(r.link(label, url))
+				i += n - 1
+				continue
+			}
+		case c == '*' || c == '_':
+			if c == '_' && i > 0 && isWordChar(text[i-1]) {
+				break
+			}
+			delim := string(c)
+			if strings.HasPrefix(text[i:], delim+delim) {
+				delim += delim
+			}
+			if end := closing(text[i+len(delim):], delim); end > 0 {
+				inner := r.inline(text[i+len(delim) : i+len(delim)+end])
+				b.WriteString(r.emphasis(inner, len(delim) == 2))
+				i += 2*len(delim) + end - 1
+				continue
+			}
+		}
+		b.WriteString(r.text(text[i : i+1]))
+	}
+	return b.String()
+}
+
+// text returns the given plain text, escaped if needed.
+func (r *renderer) text(str string) string {
+	if r.html {
+		return html.EscapeString(str)
+	}
+	return str
+}
+
+// code returns the given code span.
+func (r *renderer) code(str string) string {
+	if r.html {
+		return "<code>" + html.EscapeString(str) + "</code>"
+	}
+	return r.paint(str, colors.Yellow, colors.Regular)
+}
+
+// safeURL returns true if the given URL can be linked from HTML. Only
+// relative URLs and the "http", "https" and "mailto" schemes are allowed, so
+// links like "javascript:alert(1)" cannot run anything on exported pages.
+func safeURL(str string) bool {
+	u, err := url.Parse(str)
+	if err != nil {
+		return false
+	}
+	switch u.Scheme {
+	case "", "http", "https", "mailto":
+		return true
+	}
+	return false
+}
+
+// link returns the link with the given label and URL. On HTML, links with
+// unsafe URLs (see safeURL) are rendered as plain text.
+func (r *renderer) link(label, url string) string {
+	if r.html && safeURL(url) {
+		return fmt.Sprintf(`<a href="%v">%v</a>`, html.EscapeString(url), r.inline(label))
+	}
+	str := r.inline(label)
+	if !r.html {
+		str = r.paint(str, colors.Blue, colors.Underlined)
+	}
+	if url != label {
+		str += " (" + r.text(url) + ")"
+	}
+	return str
+}
+
+// emphasis returns the given already rendered text with emphasis, which is
+// strong if the given flag is true.
+func (r *renderer) emphasis(inner string, strong bool) string {
+	switch {
+	case r.html && strong:
+		return "<strong>" + inner + "</strong>"
+	case r.html:
+		return "<em>" + inner + "</em>"
+	case strong:
+		return r.paint(inner, colors.Saved, colors.Bold)
+	}
+	return r.paint(inner, colors.Saved, colors.Underlined)
+}
+
+// paint returns the given string with the given color and mode, unless colors
+// are disabled.
+func (r *renderer) paint(str string, fg colors.Colors, mode colors.Mode) string {
+	if !r.color || str == "" {
+		return str
+	}
+	c := &colors.Color{Foreground: fg, Background: colors.Saved, Mode: mode}
+	return c.Get(str)
+}
+
+// link parses a link (e.g. "[label](url)") at the beginning of the given text.
+// It returns the label, the URL and the length of the link, which is zero if
+// the text does not start with a link.
+func link(text string) (string, string, int) {
+	end := strings.Index(text, "](")
+	if end < 0 || strings.IndexByte(text[1:end], '[') >= 0 {
+		return "", "", 0
+	}
+	close := strings.IndexByte(text[end+2:], ')')
+	if close < 0 {
+		return "", "", 0
+	}
+	return text[1:end], text[end+2 : end+2+close], end + 3 + close
+}
+
+// closing returns the position of the given closing delimiter of emphasis
+// inside of the given text, or -1 if there is none. Delimiters surrounded by
+// spaces are not taken into account.
+func closing(text, delim string) int {
+	if text == "" || text[0] == ' ' {
+		return -1
+	}
+	for i := 1; i+len(delim) <= len(text); i++ {
+		if text[i:i+len(delim)] != delim || text[i-1] == ' ' {
+			continue
+		}
+		// Single underscores inside of words are not delimiters.
+		after := i + len(delim)
+		if delim == "_" && after < len(text) && isWordChar(text[after]) {
+			continue
+		}
+		return i
+	}
+	return -1
+}
+
+// isWordChar returns true if the given byte can be part of a word.
+func isWordChar(c byte) bool {
+	return c == '_' || c >= 0x80 || unicode.IsLetter(rune(c)) || unicode.IsDigit(rune(c))
+}
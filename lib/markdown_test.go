@@ -0,0 +1,57 @@
+// Copyright (C) 2014-2017 Miquel Sabaté Solà <mikisabate@gmail.com>
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package lib
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRenderPlain(t *testing.T) {
+	tests := []struct {
+		markdown, expected string
+	}{
+		{"# Title #", "Title"},
+		{"### Sub **title**", "Sub title"},
+		{"#hashtag", "#hashtag"},
+		{"Some **bold**, *emph* and __more__ _emph_.", "Some bold, emph and more emph."},
+		{"snake_case_name and 2 * 3 * 4", "snake_case_name and 2 * 3 * 4"},
+		{"Use `go **test**` here", "Use go **test** here"},
+		{"See [the docs](http://example.com).", "See the docs (http://example.com)."},
+		{"[http://a.b](http://a.b)", "http://a.b"},
+		{"- one\n  * two\n3. three", "• one\n  • two\n3. three"},
+		{"- [ ] todo\n- [x] done", "[ ] todo\n[x] done"},
+		{"> quoted *text*", "│ quoted text"},
+		{"---", strings.Repeat("─", 40)},
+		{"```go\nfunc *main*() {}\n```\nafter", "    func *main*() {}\nafter"},
+		{`\*not emph\*`, "*not emph*"},
+	}
+
+	for _, test := range tests {
+		if r := renderMarkdown(test.markdown, false); r != test.expected+"\n" {
+			t.Fatalf("Rendering %q: expected %q, got %q", test.markdown, test.expected+"\n", r)
+		}
+	}
+}
+
+func TestRenderColors(t *testing.T) {
+	r := renderMarkdown("# Title\n- [x] **done**\n`code`", true)
+
+	for _, str := range []string{
+		"\x1b[4;49;35mTitle\x1b[0;m",
+		"\x1b[1;49;32m[x]\x1b[0;m",
+		"\x1b[1;49;39mdone\x1b[0;m",
+		"\x1b[0;49;33mcode\x1b[0;m",
+	} {
+		if !strings.Contains(r, str) {
+			t.Fatalf("Expecting %q inside of %q", str, r)
+		}
+	}
+	if strings.Contains(r, "#") || strings.Contains(r, "**") || strings.Contains(r, "`") {
+		t.Fatalf("Markup should have been removed: %q", r)
+	}
+}
@@ -0,0 +1,271 @@
+// Copyright (C) 2014-2017 Miquel Sabaté Solà <mikisabate@gmail.com>
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package lib
+
+import (
+	"crypto/sha1"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const (
+	// The name of the directory containing the state of migrations.
+	migrationsDir = "migrations"
+)
+
+// migration is the state of a migration between two servers. It's saved after
+// each step, so an interrupted migration can be resumed. It also contains the
+// mapping between the IDs of the topics on both servers.
+type migration struct {
+	From   string          `json:"from"`
+	To     string          `json:"to"`
+	Topics []*migratedItem `json:"topics"`
+}
+
+// migratedItem is a topic being migrated.
+type migratedItem struct {
+	Name  string `json:"name"`
+	OldID string `json:"old_id"`
+
+	// The ID of the topic on the destination server. It's empty until the
+	// topic has been created.
+	NewID string `json:"new_id"`
+
+	// Whether the topic might have been created on the destination server
+	// without knowing its ID (i.e. the migration was interrupted).
+	Pending bool `json:"pending,omitempty"`
+
+	// Whether the contents of the topic on the destination server have been
+	// checked to be the same as on the origin.
+	Verified bool `json:"verified"`
+}
+
+// endpoint returns the credentials referred by the given profile or URL. An
+// empty string or "current" refer to the current session. URLs are only
+// accepted if the user has logged in to them (either on the current session
+// or on a profile).
+func endpoint(spec string) (*profile, error) {
+	current := &profile{Server: config.Server, Token: config.Token}
+	if spec == "" || spec == "current" {
+		if config.Token == "" {
+			return nil, See("you are not logged in", "login")
+		}
+		return current, nil
+	}
+	if p, ok := config.Profiles[spec]; ok {
+		return p, nil
+	}
+	if !strings.Contains(spec, "://") {
+		return nil, See(fmt.Sprintf("unknown profile '%v'", spec), "login --profile")
+	}
+
+	normalize := func(url string) string { return strings.TrimRight(url, "/") }
+	for _, p := range append([]*profile{current}, profilesOf(config)...) {
+		if p.Token != "" && normalize(p.Server) == normalize(spec) {
+			return p, nil
+		}
+	}
+	return nil, See(fmt.Sprintf("you have not logged in to '%v'", spec), "login --profile")
+}
+
+// profilesOf returns the profiles from the given configuration sorted by
+// name.
+func profilesOf(cfg *configuration) []*profile {
+	var names []string
+	var res []*profile
+
+	for name := range cfg.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		res = append(res, cfg.Profiles[name])
+	}
+	return res
+}
+
+// on runs the given function while HTTP requests are performed against the
+// server of the given profile.
+func (p *profile) on(f func() error) error {
+	server, token := config.Server, config.Token
+	config.Server, config.Token = p.Server, p.Token
+	defer func() { config.Server, config.Token = server, token }()
+
+	return f()
+}
+
+// migrationPath returns the default path of the state of the migration between
+// the given servers.
+func migrationPath(from, to string) string {
+	sum := sha1.Sum([]byte(from + "\n" + to))
+	return dataPath(migrationsDir, fmt.Sprintf("%x.json", sum[:8]))
+}
+
+// save writes the state of the migration into the given path.
+func (m *migration) save(path string) error {
+	body, _ := json.MarshalIndent(m, "", "  ")
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return writeFile(path, body, 0644)
+}
+
+// item returns the state of the topic with the given ID on the origin server,
+// which is created if it does not exist yet.
+func (m *migration) item(t *Topic) *migratedItem {
+	for _, it := range m.Topics {
+		if it.OldID == t.ID {
+			return it
+		}
+	}
+	it := &migratedItem{Name: t.Name, OldID: t.ID}
+	m.Topics = append(m.Topics, it)
+	return it
+}
+
+// Migrate copies all the topics from one server to another. Servers are
+// referred either by the name of a profile or by their URL (see endpoint).
+// The state of the migration is saved into the given path (or into the
+// "migrations" directory if empty) after each step, so running it again
+// resumes an interrupted migration. This state contains the mapping between
+// the IDs of the topics on both servers. Topics are verified after being
+// copied, and the ones that have already been verified are skipped.
+func Migrate(from, to, path string) error {
+	src, err := endpoint(from)
+	if err != nil {
+		return err
+	}
+	dst, err := endpoint(to)
+	if err != nil {
+		return err
+	}
+	if strings.TrimRight(src.Server, "/") == strings.TrimRight(dst.Server, "/") {
+		return NewError("cannot migrate topics into the same server")
+	}
+
+	unlock, err := lockCache()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	// Load the state of a previous migration, if any.
+	if path == "" {
+		path = migrationPath(src.Server, dst.Server)
+	}
+	m := &migration{From: src.Server, To: dst.Server}
+	if body, err := ioutil.ReadFile(path); err == nil {
+		if err := json.Unmarshal(body, m); err != nil {
+			return NewError(fmt.Sprintf("could not read '%v': %v", path, err))
+		}
+		if m.From != src.Server || m.To != dst.Server {
+			return NewError(fmt.Sprintf("'%v' belongs to a migration from '%v' to '%v'", path, m.From, m.To))
+		}
+		fmt.Printf("Resuming the migration from '%v'.\n", path)
+	}
+
+	var topics, existing []Topic
+	if err := src.on(func() (err error) { topics, err = serverTopics(); return }); err != nil {
+		return err
+	}
+	if err := dst.on(func() (err error) { existing, err = serverTopics(); return }); err != nil {
+		return err
+	}
+
+	save := func() error { return m.save(path) }
+	var fails []string
+	copied := 0
+	for k, t := range topics {
+		it := m.item(&topics[k])
+		if it.Verified {
+			continue
+		}
+		fmt.Printf("Copying '%v'... %v/%v\n", t.Name, k+1, len(topics))
+		if err := copyTopic(dst, &topics[k], it, existing, save); err != nil {
+			warning("%v.", fmt.Sprintf("could not copy '%v': %v", t.Name, errorMessage(err)))
+			fails = append(fails, t.Name)
+			continue
+		}
+		copied++
+	}
+
+	// Verify all the copied topics.
+	if err := dst.on(func() (err error) { existing, err = serverTopics(); return }); err != nil {
+		return err
+	}
+	for _, t := range topics {
+		it := m.item(&t)
+		if it.Verified || it.NewID == "" {
+			continue
+		}
+		for _, e := range existing {
+			if e.ID == it.NewID && e.Name == t.Name && e.Contents == t.Contents {
+				it.Verified = true
+			}
+		}
+		if !it.Verified && !contains(fails, t.Name) {
+			fails = append(fails, t.Name)
+		}
+	}
+	if err := m.save(path); err != nil {
+		return fromError(err)
+	}
+
+	fmt.Printf("Copied %v topics from '%v' to '%v'. The mapping of IDs is in '%v'.\n",
+		copied, src.Server, dst.Server, path)
+	if len(fails) > 0 {
+		return See("could not migrate: "+strings.Join(fails, ", "), "migrate")
+	}
+	return nil
+}
+
+// copyTopic copies the given topic into the given destination server, whose
+// topics are also given. The given function saves the state of the migration.
+// Topics are flagged as pending before being created, so if the migration is
+// interrupted right after creating one, it's picked up again on the next run
+// instead of being created twice.
+func copyTopic(dst *profile, t *Topic, it *migratedItem, existing []Topic, save func() error) error {
+	if it.NewID == "" {
+		for _, e := range existing {
+			if e.Name != t.Name {
+				continue
+			}
+			if !it.Pending {
+				return fmt.Errorf("there is already a topic named '%v' on the destination", t.Name)
+			}
+			it.NewID = e.ID
+		}
+	}
+
+	if it.NewID == "" {
+		it.Pending = true
+		if err := save(); err != nil {
+			return err
+		}
+		err := dst.on(func() error {
+			nt, err := createTopic(t.Name)
+			if err == nil {
+				it.NewID = nt.ID
+			}
+			return err
+		})
+		if err != nil {
+			return err
+		}
+	}
+	it.Pending = false
+	if err := save(); err != nil {
+		return err
+	}
+
+	return dst.on(func() error { return pushContents(it.NewID, t.Contents) })
+}
@@ -0,0 +1,189 @@
+// Copyright (C) 2014-2017 Miquel Sabaté Solà <mikisabate@gmail.com>
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package lib
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mssola/capture"
+)
+
+// destination is a server where topics are migrated to. Pushing the contents
+// of the topic named as failing fails.
+type destination struct {
+	topics  []Topic
+	failing string
+}
+
+func (d *destination) serve() *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var p params
+		_ = json.NewDecoder(r.Body).Decode(&p)
+		id := strings.TrimPrefix(r.URL.Path, "/topics/")
+
+		switch r.Method {
+		case "GET":
+			b, _ := json.Marshal(d.topics)
+			fmt.Fprint(w, string(b))
+		case "POST":
+			t := Topic{ID: fmt.Sprintf("new%v", len(d.topics)+1), Name: p.Name}
+			d.topics = append(d.topics, t)
+			b, _ := json.Marshal(&t)
+			fmt.Fprint(w, string(b))
+		case "PUT":
+			for k, t := range d.topics {
+				if t.ID != id {
+					continue
+				}
+				if t.Name == d.failing {
+					w.WriteHeader(http.StatusInternalServerError)
+					return
+				}
+				d.topics[k].Contents = p.Contents
+				b, _ := json.Marshal(&d.topics[k])
+				fmt.Fprint(w, string(b))
+			}
+		}
+	}))
+}
+
+func TestEndpoint(t *testing.T) {
+	config = &configuration{
+		Server:   "https://current.org",
+		Token:    "1",
+		Profiles: map[string]*profile{"other": {Server: "https://other.org/", Token: "2"}},
+	}
+
+	for spec, token := range map[string]string{
+		"":                    "1",
+		"current":             "1",
+		"https://current.org": "1",
+		"other":               "2",
+		"https://other.org":   "2",
+	} {
+		p, err := endpoint(spec)
+		if err != nil || p.Token != token {
+			t.Fatalf("Unexpected endpoint for '%v': %+v (%v)", spec, p, err)
+		}
+	}
+
+	if _, err := endpoint("unknown"); err == nil || !strings.Contains(err.Error(), "unknown profile 'unknown'") {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if _, err := endpoint("https://unknown.org"); err == nil || !strings.Contains(err.Error(), "you have not logged in to") {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	startTestEnv(t)
+	defer stopTestEnv(t)
+
+	src := topicServer(nil)
+	defer src.Close()
+	dst := &destination{failing: "topic2"}
+	ds := dst.serve()
+	defer ds.Close()
+
+	config = &configuration{
+		Server:   src.URL,
+		Token:    "1234",
+		Profiles: map[string]*profile{"new": {Server: ds.URL, Token: "5678"}},
+	}
+
+	dir, err := ioutil.TempDir("", "td-migrate")
+	errCheck(t, err)
+	defer func() { _ = os.RemoveAll(dir) }()
+	state := filepath.Join(dir, "state.json")
+
+	// The second topic cannot be copied.
+	capture.All(func() { err = Migrate("", "new", state) })
+	if err == nil || !strings.Contains(err.Error(), "could not migrate: topic2") {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(dst.topics) != 2 || dst.topics[0].Contents != "1111" {
+		t.Fatalf("Unexpected topics: %v", dst.topics)
+	}
+
+	// Resuming the migration does not create topics twice.
+	dst.failing = ""
+	dst.topics[0].Contents = "changed"
+	res := capture.All(func() { err = Migrate(src.URL, ds.URL, state) })
+	if err != nil {
+		t.Fatalf("Not expecting error: %v", err)
+	}
+	if !strings.Contains(string(res.Stdout), "Copied 1 topics") {
+		t.Fatalf("Unexpected output: %s", res.Stdout)
+	}
+	if len(dst.topics) != 2 || dst.topics[0].Contents != "changed" || dst.topics[1].Contents != "2222" {
+		t.Fatalf("Unexpected topics: %v", dst.topics)
+	}
+
+	// The mapping of IDs.
+	var m migration
+	body, err := ioutil.ReadFile(state)
+	errCheck(t, err)
+	errCheck(t, json.Unmarshal(body, &m))
+	if len(m.Topics) != 2 {
+		t.Fatalf("Unexpected migration: %+v", m)
+	}
+	for k, it := range m.Topics {
+		if it.OldID != testTopics[k].ID || it.NewID != dst.topics[k].ID || !it.Verified || it.Pending {
+			t.Fatalf("Unexpected item: %+v", it)
+		}
+	}
+}
+
+func TestMigratePending(t *testing.T) {
+	startTestEnv(t)
+	defer stopTestEnv(t)
+
+	src := topicServer(nil)
+	defer src.Close()
+	dst := &destination{topics: []Topic{{ID: "new1", Name: "topic1"}, {ID: "new2", Name: "topic2"}}}
+	ds := dst.serve()
+	defer ds.Close()
+
+	config = &configuration{
+		Server:   src.URL,
+		Token:    "1234",
+		Profiles: map[string]*profile{"new": {Server: ds.URL, Token: "5678"}},
+	}
+
+	// The first topic was created by an interrupted migration, but the
+	// second one was already there.
+	m := &migration{From: src.URL, To: ds.URL, Topics: []*migratedItem{
+		{Name: "topic1", OldID: "1", Pending: true},
+	}}
+	path := migrationPath(src.URL, ds.URL)
+	errCheck(t, m.save(path))
+
+	var err error
+	capture.All(func() { err = Migrate("current", "new", "") })
+	if err == nil || !strings.Contains(err.Error(), "could not migrate: topic2") {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(dst.topics) != 2 || dst.topics[0].Contents != "1111" || dst.topics[1].Contents != "" {
+		t.Fatalf("Unexpected topics: %v", dst.topics)
+	}
+
+	capture.All(func() { err = Migrate("new", "current", path) })
+	if err == nil || !strings.Contains(err.Error(), "belongs to a migration from") {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if err = Migrate("current", src.URL, ""); err == nil || !strings.Contains(err.Error(), "same server") {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+}
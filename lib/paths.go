@@ -0,0 +1,155 @@
+// Copyright (C) 2014-2017 Miquel Sabaté Solà <mikisabate@gmail.com>
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package lib
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+const (
+	// The name of the directory of td inside of the XDG base directories.
+	xdgName = "td"
+
+	// The directory inside of the data directory containing the data of the
+	// sessions given by the environment (see envSessionKey).
+	envDir = "env"
+)
+
+// dirs contains the directories where td keeps its files.
+type dirs struct {
+	// The configuration: the config file and the hooks.
+	config string
+
+	// Everything else: the cached topics and the local changes, the lock,
+	// the state of migrations, etc. Note that local changes cannot be
+	// recovered from the server, so this is not a cache directory.
+	data string
+}
+
+// xdgDir returns the XDG base directory from the given environment variable,
+// or the given default path inside of the $HOME directory.
+func xdgDir(env, def string) string {
+	if value := os.Getenv(env); filepath.IsAbs(value) {
+		return filepath.Join(value, xdgName)
+	}
+	return filepath.Join(home(), def, xdgName)
+}
+
+// xdgDirs returns the directories as given by the XDG Base Directory
+// specification.
+func xdgDirs() dirs {
+	return dirs{
+		config: xdgDir("XDG_CONFIG_HOME", ".config"),
+		data:   xdgDir("XDG_DATA_HOME", filepath.Join(".local", "share")),
+	}
+}
+
+// legacyDir returns the directory that contained everything on previous
+// versions (e.g. "~/.td").
+func legacyDir() string {
+	return filepath.Join(home(), dirName)
+}
+
+// resolveDirs returns the directories being used. If the $TD environment
+// variable is set, then everything lives inside of the "$TD/.td" directory.
+// Otherwise the XDG base directories are used, unless the files from previous
+// versions have not been moved yet (see migrateDirs). Sessions given by the
+// environment use a data directory of their own (see envSessionKey).
+func resolveDirs() dirs {
+	d := dirs{config: legacyDir(), data: legacyDir()}
+	if tdHome() == "" {
+		xdg := xdgDirs()
+		if _, err := os.Stat(d.data); err != nil || xdg.exist() {
+			d = xdg
+		}
+	}
+
+	if key := envSessionKey(); key != "" {
+		d.data = filepath.Join(d.data, envDir, key)
+	}
+	return d
+}
+
+// exist returns true if any of the files from the given directories exist.
+func (d dirs) exist() bool {
+	for _, path := range []string{d.data, filepath.Join(d.config, configName)} {
+		if _, err := os.Stat(path); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// configPath returns the path of the given file inside of the configuration
+// directory.
+func configPath(elem ...string) string {
+	return filepath.Join(append([]string{resolveDirs().config}, elem...)...)
+}
+
+// dataPath returns the path of the given file inside of the data directory.
+func dataPath(elem ...string) string {
+	return filepath.Join(append([]string{resolveDirs().data}, elem...)...)
+}
+
+// migrateDirs moves the files from the directory of previous versions (e.g.
+// "~/.td") into the XDG base directories. This is only done once and only if
+// the $TD environment variable is not set. If something goes wrong, then the
+// files are left where they were, and they keep being used from there.
+func migrateDirs() {
+	legacy := legacyDir()
+	if tdHome() != "" {
+		return
+	}
+	if _, err := os.Stat(legacy); err != nil {
+		return
+	}
+
+	xdg := xdgDirs()
+	if xdg.exist() {
+		warning("ignoring '%v', since td already uses the XDG base directories.", legacy)
+		return
+	}
+	if err := moveDirs(legacy, xdg); err != nil {
+		warning("%v.", fmt.Sprintf("could not move '%v' into the XDG base directories: %v", legacy, err))
+		return
+	}
+	progress("Moved '%v' into '%v' and '%v'.\n", legacy, xdg.config, xdg.data)
+}
+
+// moveDirs moves the given directory of previous versions into the given
+// directories. Everything is moved into the data directory first, and then
+// the configuration is moved into its own directory. Changes are undone if
+// something goes wrong.
+func moveDirs(legacy string, d dirs) error {
+	if err := os.MkdirAll(filepath.Dir(d.data), 0755); err != nil {
+		return err
+	}
+	if err := os.Rename(legacy, d.data); err != nil {
+		return err
+	}
+
+	var moved []string
+	err := os.MkdirAll(d.config, 0755)
+	for _, name := range []string{configName, hooksDir} {
+		src := filepath.Join(d.data, name)
+		if _, statErr := os.Stat(src); err != nil || statErr != nil {
+			continue
+		}
+		if err = os.Rename(src, filepath.Join(d.config, name)); err == nil {
+			moved = append(moved, name)
+		}
+	}
+	if err != nil {
+		for _, name := range moved {
+			_ = os.Rename(filepath.Join(d.config, name), filepath.Join(d.data, name))
+		}
+		_ = os.Rename(d.data, legacy)
+	}
+	return err
+}
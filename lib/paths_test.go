@@ -0,0 +1,148 @@
+// Copyright (C) 2014-2017 Miquel Sabaté Solà <mikisabate@gmail.com>
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package lib
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mssola/capture"
+)
+
+// The environment variables changed by startXDGEnv.
+var xdgEnv = []string{"TD", "HOME", "XDG_CONFIG_HOME", "XDG_DATA_HOME"}
+
+// startXDGEnv sets up a $HOME without the $TD environment variable, so the
+// XDG base directories are used. It returns the path of this $HOME and the
+// previous values of the environment (see stopXDGEnv).
+func startXDGEnv(t *testing.T) (string, []string) {
+	config = &configuration{}
+	dir := filepath.Join(getWd(), "xdg")
+	errCheck(t, os.RemoveAll(dir))
+	errCheck(t, os.MkdirAll(dir, 0755))
+
+	var old []string
+	for _, k := range xdgEnv {
+		old = append(old, os.Getenv(k))
+		errCheck(t, os.Setenv(k, ""))
+	}
+	errCheck(t, os.Setenv("HOME", dir))
+	return dir, old
+}
+
+func stopXDGEnv(t *testing.T, old []string) {
+	errCheck(t, os.RemoveAll(filepath.Join(getWd(), "xdg")))
+	for i, k := range xdgEnv {
+		errCheck(t, os.Setenv(k, old[i]))
+	}
+}
+
+func TestPathsLegacy(t *testing.T) {
+	startTestEnv(t)
+	defer stopTestEnv(t)
+
+	dir := filepath.Join(home(), dirName)
+	if configPath(configName) != filepath.Join(dir, configName) {
+		t.Fatalf("Unexpected path: %v", configPath(configName))
+	}
+	if dataPath(newDir) != filepath.Join(dir, newDir) {
+		t.Fatalf("Unexpected path: %v", dataPath(newDir))
+	}
+}
+
+func TestPathsXDG(t *testing.T) {
+	h, old := startXDGEnv(t)
+	defer stopXDGEnv(t, old)
+
+	if configPath(configName) != filepath.Join(h, ".config", "td", configName) {
+		t.Fatalf("Unexpected path: %v", configPath(configName))
+	}
+	if dataPath(newDir) != filepath.Join(h, ".local", "share", "td", newDir) {
+		t.Fatalf("Unexpected path: %v", dataPath(newDir))
+	}
+
+	// Relative paths are ignored, as the specification says.
+	errCheck(t, os.Setenv("XDG_CONFIG_HOME", "relative"))
+	errCheck(t, os.Setenv("XDG_DATA_HOME", filepath.Join(h, "data")))
+	if configPath() != filepath.Join(h, ".config", "td") {
+		t.Fatalf("Unexpected path: %v", configPath())
+	}
+	if dataPath() != filepath.Join(h, "data", "td") {
+		t.Fatalf("Unexpected path: %v", dataPath())
+	}
+}
+
+func TestMigrateDirs(t *testing.T) {
+	h, old := startXDGEnv(t)
+	defer stopXDGEnv(t, old)
+	legacy := filepath.Join(h, dirName)
+	errCheck(t, os.MkdirAll(filepath.Join(legacy, newDir), 0755))
+	errCheck(t, os.MkdirAll(filepath.Join(legacy, hooksDir), 0755))
+	errCheck(t, ioutil.WriteFile(filepath.Join(legacy, configName), []byte(`{"token":"1234"}`), 0644))
+	errCheck(t, ioutil.WriteFile(filepath.Join(legacy, newDir, "topic.md"), []byte("contents"), 0644))
+	errCheck(t, ioutil.WriteFile(filepath.Join(legacy, hooksDir, postFetch), []byte("#!/bin/sh\n"), 0755))
+
+	// The old directory is still used until it gets moved.
+	if dataPath() != legacy || configPath() != legacy {
+		t.Fatalf("Expected to use '%v', got '%v' and '%v'", legacy, dataPath(), configPath())
+	}
+
+	res := capture.All(func() { Initialize() })
+	if _, err := os.Stat(legacy); !os.IsNotExist(err) {
+		t.Fatalf("Expected '%v' to be moved", legacy)
+	}
+	if len(res.Stdout) != 0 || !strings.Contains(string(res.Stderr), "Moved '"+legacy+"'") {
+		t.Fatalf("Unexpected output: %q, %q", res.Stdout, res.Stderr)
+	}
+	if !config.logged {
+		t.Fatal("Expected to keep the session")
+	}
+	for _, path := range []string{
+		filepath.Join(h, ".config", "td", configName),
+		filepath.Join(h, ".config", "td", hooksDir, postFetch),
+		filepath.Join(h, ".local", "share", "td", newDir, "topic.md"),
+	} {
+		if _, err := os.Stat(path); err != nil {
+			t.Fatalf("Expected '%v' to exist: %v", path, err)
+		}
+	}
+
+	// It's only done once.
+	errCheck(t, os.MkdirAll(legacy, 0755))
+	migrateDirs()
+	if _, err := os.Stat(legacy); err != nil {
+		t.Fatalf("Expected '%v' to be left alone", legacy)
+	}
+	if dataPath() != filepath.Join(h, ".local", "share", "td") {
+		t.Fatalf("Unexpected path: %v", dataPath())
+	}
+}
+
+func TestMigrateDirsUndo(t *testing.T) {
+	h, old := startXDGEnv(t)
+	defer stopXDGEnv(t, old)
+	legacy := filepath.Join(h, dirName)
+	errCheck(t, os.MkdirAll(legacy, 0755))
+	errCheck(t, ioutil.WriteFile(filepath.Join(legacy, configName), []byte("{}"), 0644))
+
+	// The configuration directory cannot be created.
+	errCheck(t, ioutil.WriteFile(filepath.Join(h, ".config"), []byte(""), 0644))
+
+	migrateDirs()
+	if _, err := os.Stat(filepath.Join(legacy, configName)); err != nil {
+		t.Fatalf("Expected the old directory to be restored: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(h, ".local", "share", "td")); !os.IsNotExist(err) {
+		t.Fatal("Expected the data directory to be removed")
+	}
+	if dataPath() != legacy {
+		t.Fatalf("Unexpected path: %v", dataPath())
+	}
+}
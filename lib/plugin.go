@@ -0,0 +1,113 @@
+// Copyright (C) 2014-2017 Miquel Sabaté Solà <mikisabate@gmail.com>
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package lib
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+)
+
+const (
+	// The prefix of the executables that provide extra commands. That is, the
+	// "td-hello" executable provides the "hello" command.
+	pluginPrefix = "td-"
+)
+
+// Plugin is an executable from the $PATH that provides an extra command.
+type Plugin struct {
+	Name string
+	Path string
+}
+
+// Plugins returns the plugins available on the $PATH sorted by name. As it
+// happens with the shell, if there are many executables for the same plugin,
+// the first one on the $PATH wins.
+func Plugins() []Plugin {
+	var plugins []Plugin
+	seen := make(map[string]bool)
+
+	for _, dir := range filepath.SplitList(os.Getenv("PATH")) {
+		files, err := ioutil.ReadDir(dir)
+		if err != nil {
+			continue
+		}
+		for _, fi := range files {
+			name := strings.TrimPrefix(fi.Name(), pluginPrefix)
+			if !strings.HasPrefix(fi.Name(), pluginPrefix) || name == "" || seen[name] {
+				continue
+			}
+			if fi.Mode().IsRegular() && fi.Mode()&0111 != 0 {
+				seen[name] = true
+				plugins = append(plugins, Plugin{Name: name, Path: filepath.Join(dir, fi.Name())})
+			}
+		}
+	}
+	sort.Slice(plugins, func(i, j int) bool { return plugins[i].Name < plugins[j].Name })
+	return plugins
+}
+
+// FindPlugin returns the path of the executable for the plugin with the given
+// name, if any.
+func FindPlugin(name string) (string, bool) {
+	if name == "" || strings.HasPrefix(name, "-") || strings.ContainsAny(name, `/\`) {
+		return "", false
+	}
+	path, err := exec.LookPath(pluginPrefix + name)
+	return path, err == nil
+}
+
+// pluginEnv returns the environment for plugins, which contains the
+// following variables on top of the current environment:
+//
+//   - TD: the path of the td executable.
+//   - TD_HOME: the original value of $TD, if any (see tdHome).
+//   - TD_DIR: the directory where td stores its data.
+//   - TD_CONFIG_DIR: the directory containing the configuration file and the
+//     hooks.
+//   - TD_SERVER: the URL of the server of the current session.
+//   - TD_TOPICS: the cached list of topics.
+//   - TD_CACHE: the directory containing the local copy of each topic.
+func pluginEnv() []string {
+	dir := dataPath()
+	exe, err := os.Executable()
+	if err != nil {
+		exe = os.Args[0]
+	}
+	return append(os.Environ(),
+		"TD="+exe,
+		"TD_HOME="+tdHome(),
+		"TD_DIR="+dir,
+		"TD_CONFIG_DIR="+configPath(),
+		"TD_SERVER="+config.Server,
+		"TD_TOPICS="+filepath.Join(dir, topicsName),
+		"TD_CACHE="+filepath.Join(dir, newDir),
+	)
+}
+
+// RunPlugin runs the plugin with the given path and arguments (see FindPlugin
+// and pluginEnv). It returns the exit status of the plugin.
+func RunPlugin(path string, args []string) (int, error) {
+	cmd := exec.Command(path, args...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	cmd.Env = pluginEnv()
+
+	err := cmd.Run()
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		if status, ok := exitErr.Sys().(syscall.WaitStatus); ok {
+			return status.ExitStatus(), nil
+		}
+		return 1, nil
+	} else if err != nil {
+		return 1, fromError(err)
+	}
+	return 0, nil
+}
@@ -0,0 +1,117 @@
+// Copyright (C) 2014-2017 Miquel Sabaté Solà <mikisabate@gmail.com>
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package lib
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mssola/capture"
+)
+
+// pluginDirs creates two directories with plugins and puts them on the $PATH.
+func pluginDirs(t *testing.T) (string, string, func()) {
+	first, err := ioutil.TempDir("", "td-plugins")
+	errCheck(t, err)
+	second, err := ioutil.TempDir("", "td-plugins")
+	errCheck(t, err)
+
+	script := "#!/bin/sh\necho \"$@\"\necho \"$TD|$TD_HOME|$TD_SERVER|$TD_CACHE|$TD_TOPICS\"\nexit 3\n"
+	errCheck(t, ioutil.WriteFile(filepath.Join(first, "td-hello"), []byte(script), 0755))
+	errCheck(t, ioutil.WriteFile(filepath.Join(second, "td-hello"), []byte(script), 0755))
+	errCheck(t, ioutil.WriteFile(filepath.Join(second, "td-bye"), []byte(script), 0755))
+	errCheck(t, ioutil.WriteFile(filepath.Join(second, "td-nope"), []byte(script), 0644))
+	errCheck(t, ioutil.WriteFile(filepath.Join(second, "hello"), []byte(script), 0755))
+
+	path := os.Getenv("PATH")
+	_ = os.Setenv("PATH", first+string(filepath.ListSeparator)+second)
+	return first, second, func() {
+		_ = os.Setenv("PATH", path)
+		_ = os.RemoveAll(first)
+		_ = os.RemoveAll(second)
+	}
+}
+
+func TestPlugins(t *testing.T) {
+	first, second, cleanup := pluginDirs(t)
+	defer cleanup()
+
+	plugins := Plugins()
+	if len(plugins) != 2 {
+		t.Fatalf("Expected two plugins, got: %v", plugins)
+	}
+	if plugins[0].Name != "bye" || plugins[0].Path != filepath.Join(second, "td-bye") {
+		t.Fatalf("Unexpected plugin: %v", plugins[0])
+	}
+	if plugins[1].Name != "hello" || plugins[1].Path != filepath.Join(first, "td-hello") {
+		t.Fatalf("Unexpected plugin: %v", plugins[1])
+	}
+}
+
+func TestFindPlugin(t *testing.T) {
+	first, _, cleanup := pluginDirs(t)
+	defer cleanup()
+
+	if path, ok := FindPlugin("hello"); !ok || path != filepath.Join(first, "td-hello") {
+		t.Fatalf("Unexpected plugin: %v", path)
+	}
+	for _, name := range []string{"nope", "", "-hello", "../td-hello", "whatever"} {
+		if path, ok := FindPlugin(name); ok {
+			t.Fatalf("Did not expect to find '%v', got: %v", name, path)
+		}
+	}
+}
+
+func TestRunPlugin(t *testing.T) {
+	startTestEnv(t)
+	defer stopTestEnv(t)
+	_, _, cleanup := pluginDirs(t)
+	defer cleanup()
+
+	config = &configuration{Server: "http://td.example.com", Token: "1234"}
+	path, _ := FindPlugin("hello")
+
+	var status int
+	var err error
+	res := capture.All(func() { status, err = RunPlugin(path, []string{"a", "--b"}) })
+	errCheck(t, err)
+	if status != 3 {
+		t.Fatalf("Expected the exit status of the plugin, got: %v", status)
+	}
+
+	exe, err := os.Executable()
+	errCheck(t, err)
+	dir := filepath.Join(home(), dirName)
+	lines := strings.Split(strings.TrimSpace(string(res.Stdout)), "\n")
+	compareSlices(t, lines, []string{
+		"a --b",
+		exe + "|" + home() + "|http://td.example.com|" + filepath.Join(dir, newDir) + "|" + filepath.Join(dir, topicsName),
+	})
+}
+
+func TestPluginHome(t *testing.T) {
+	startTestEnv(t)
+	defer stopTestEnv(t)
+
+	// td being run from a plugin uses the same directories.
+	original := home()
+	exe, err := os.Executable()
+	errCheck(t, err)
+	errCheck(t, os.Setenv("TD", exe))
+	errCheck(t, os.Setenv("TD_HOME", original))
+	defer func() { _ = os.Unsetenv("TD_HOME") }()
+
+	if home() != original {
+		t.Fatalf("Expected %v; got %v", original, home())
+	}
+	if dataPath() != filepath.Join(original, dirName) {
+		t.Fatalf("Unexpected data directory: %v", dataPath())
+	}
+}
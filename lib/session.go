@@ -8,6 +8,7 @@ package lib
 
 import (
 	"bytes"
+	"crypto/sha1"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
@@ -27,6 +28,63 @@ func LoggedIn() bool {
 	return config.logged
 }
 
+// envSession returns the environment variable that gives the session instead
+// of the configuration file, if any: $TD_TOKEN (together with $TD_SERVER) or
+// $TD_PROFILE. Note that $TD_SERVER alone is ignored, since it's also given to
+// plugins and hooks (see pluginEnv).
+func envSession() string {
+	for _, name := range []string{"TD_TOKEN", "TD_PROFILE"} {
+		if os.Getenv(name) != "" {
+			return name
+		}
+	}
+	return ""
+}
+
+// envSessionKey returns a key that identifies the session given by the
+// environment, or an empty string if there is none. Sessions given by the
+// environment get their own data directory (see resolveDirs), so they don't
+// mix their topics with the ones from the configuration file.
+func envSessionKey() string {
+	var spec string
+
+	switch envSession() {
+	case "TD_TOKEN":
+		spec = os.Getenv("TD_SERVER") + "\n" + os.Getenv("TD_TOKEN")
+	case "TD_PROFILE":
+		spec = "profile\n" + os.Getenv("TD_PROFILE")
+	default:
+		return ""
+	}
+	sum := sha1.Sum([]byte(spec))
+	return fmt.Sprintf("%x", sum[:8])
+}
+
+// useEnvSession replaces the session from the configuration file with the one
+// given by the environment, if any (see envSession). The session from the file
+// is kept aside, since it's the one to be saved (see saveConfig).
+func useEnvSession() {
+	name := envSession()
+	if name == "" {
+		return
+	}
+	config.file = &profile{Server: config.Server, Token: config.Token}
+	config.Server, config.Token = "", ""
+
+	if name == "TD_TOKEN" {
+		if server := os.Getenv("TD_SERVER"); server != "" {
+			config.Server, config.Token = server, os.Getenv("TD_TOKEN")
+		} else {
+			warning("ignoring $TD_TOKEN, since $TD_SERVER is not set.", "")
+		}
+	} else if p, ok := config.Profiles[os.Getenv("TD_PROFILE")]; ok {
+		config.Server, config.Token = p.Server, p.Token
+	} else {
+		warning("ignoring $TD_PROFILE, since there is no profile named '%v'.", os.Getenv("TD_PROFILE"))
+	}
+	config.logged = config.Token != ""
+}
+
 // performLogin performs the HTTP request to log in the given user. This
 // function assumes that the configuration has already been updated with the
 // server to be used.
@@ -55,6 +113,16 @@ func performLogin(username, password string) error {
 
 // Login performs the login command.
 func Login(server, username, password string) error {
+	if name := envSession(); name != "" {
+		return NewError(fmt.Sprintf("cannot log in while $%v is set", name))
+	}
+
+	unlock, err := lockCache()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
 	// Perform the login itself.
 	config.Server = server
 	if err := performLogin(username, password); err != nil {
@@ -73,10 +141,129 @@ func Login(server, username, password string) error {
 	return fetch()
 }
 
-// Logout removes the `.td` directory and everything inside of it.
-func Logout() error {
-	cfg := filepath.Join(home(), dirName)
-	_ = os.RemoveAll(cfg)
-	config.logged = false
+// LoginProfile logs in the given user into the given server, and saves the
+// credentials into a profile with the given name. The current session is not
+// modified, so this can be used to have credentials for other servers (e.g.
+// for the `migrate` command).
+func LoginProfile(name, server, username, password string) error {
+	if name == "" {
+		return NewError("the name of a profile cannot be empty")
+	}
+
+	unlock, err := lockCache()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	// Note that logging in updates the configuration.
+	current := *config
+	config.Server = server
+	err = performLogin(username, password)
+	p := &profile{Server: config.Server, Token: config.Token}
+	*config = current
+	if err != nil {
+		return err
+	}
+
+	if config.Profiles == nil {
+		config.Profiles = make(map[string]*profile)
+	}
+	config.Profiles[name] = p
+	if err := saveConfig(); err != nil {
+		return fromError(err)
+	}
+	fmt.Printf("Saved the credentials for '%v' into the '%v' profile.\n", server, name)
+	return nil
+}
+
+// revokeToken tells the server to invalidate the current token. Servers that
+// do not implement the logout endpoint are silently ignored, since there is
+// nothing else that we can do about it. Any other failure is returned to the
+// caller.
+func revokeToken() error {
+	res, err := getResponse("POST", "/logout", nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = res.Body.Close() }()
+
+	switch {
+	case res.StatusCode == http.StatusNotFound, res.StatusCode == http.StatusMethodNotAllowed:
+		return nil
+	case res.StatusCode >= 300:
+		return fmt.Errorf("the server replied with '%v'", res.Status)
+	}
 	return nil
 }
+
+// Logout invalidates the current token on the server, removes the cached
+// topics and forgets the credentials of the current session. The rest of the
+// configuration is kept. If there are changes that have not been pushed yet
+// (including new files), then the user will be offered to push them first. If
+// the user refuses to do so, then the logout is aborted unless `force` is set
+// to true. Sessions given by the environment cannot be logged out from (see
+// envSession).
+func Logout(force bool) error {
+	if name := envSession(); name != "" {
+		return NewError(fmt.Sprintf("cannot log out while $%v is set", name))
+	}
+
+	unlock, err := lockCache()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	// New files are unpushed work too: they become topics when synchronized.
+	if !force && unpushedChanges() {
+		if !confirm("You have changes that have not been pushed. Push them now?") {
+			return See("you have changes that have not been pushed", "logout --force")
+		}
+		err := syncTopics(nil, conflictedTopics(changedTopics()))
+		if err != nil || unpushedChanges() {
+			return See("some changes could not be pushed", "logout --force")
+		}
+	}
+
+	if err := revokeToken(); err != nil {
+		warning("the token could not be revoked on the server: %v.", errorMessage(err))
+	}
+
+	removeData()
+
+	// The rest of the settings are kept, since they are not tied to the
+	// session. Profiles with the same credentials are no longer valid either.
+	for _, p := range config.Profiles {
+		if p.Server == config.Server && p.Token == config.Token {
+			p.Token = ""
+		}
+	}
+	config.Server, config.Token = "", ""
+	config.logged = false
+	return saveConfig()
+}
+
+// unpushedChanges returns true if there are changed topics or files of topics
+// that have not been created on the server yet.
+func unpushedChanges() bool {
+	return len(changedTopics()) > 0 || len(createdTopics()) > 0
+}
+
+// removeData removes everything inside of the data directory, except for the
+// files kept by keepFile. The configuration file and the hooks are kept too
+// when they live in the same directory (see resolveDirs).
+func removeData() {
+	data := dataPath()
+	keep := []string{keptDir}
+	if data == configPath() {
+		keep = append(keep, configName, hooksDir)
+	}
+
+	entries, _ := ioutil.ReadDir(data)
+	for _, e := range entries {
+		if !contains(keep, e.Name()) {
+			_ = os.RemoveAll(filepath.Join(data, e.Name()))
+		}
+	}
+}
@@ -166,14 +166,321 @@ func TestLogout(t *testing.T) {
 
 	// Login & logout
 	testLogin(t, ts.URL, username, password)
-	if err := Logout(); err != nil {
+	if err := Logout(false); err != nil {
 		t.Fatalf("Should not given an error: %v", err)
 	}
 	if LoggedIn() {
 		t.Fatalf("It says that it's logged in when it's not!")
 	}
-	cfg := filepath.Join(home(), dirName)
-	if _, err := os.Stat(cfg); err == nil || !os.IsNotExist(err) {
-		t.Fatalf("Oops: %v", err)
+	if _, err := os.Stat(filepath.Join(home(), dirName, topicsName)); !os.IsNotExist(err) {
+		t.Fatalf("The cache should have been removed: %v", err)
+	}
+}
+
+func TestLogoutKeepsConfig(t *testing.T) {
+	startTestEnv(t)
+	defer stopTestEnv(t)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer ts.Close()
+
+	config = &configuration{
+		Server: ts.URL,
+		Token:  "1234",
+		Ignore: []string{"*.tmp"},
+		Profiles: map[string]*profile{
+			"current": {Server: ts.URL, Token: "1234"},
+			"other":   {Server: "http://other", Token: "5678"},
+		},
+		logged: true,
+	}
+	errCheck(t, saveConfig())
+	writeHook(t, postCreate, 0, 0755)
+
+	if err := Logout(false); err != nil {
+		t.Fatalf("Should not given an error: %v", err)
+	}
+
+	var cfg configuration
+	body, err := ioutil.ReadFile(filepath.Join(home(), dirName, configName))
+	errCheck(t, err)
+	errCheck(t, json.Unmarshal(body, &cfg))
+	if cfg.Server != "" || cfg.Token != "" {
+		t.Fatalf("The session should have been cleared: %+v", cfg)
+	}
+	compareSlices(t, cfg.Ignore, []string{"*.tmp"})
+	if cfg.Profiles["current"].Token != "" {
+		t.Fatalf("The token of the current profile should have been cleared")
+	}
+	if cfg.Profiles["other"].Token != "5678" {
+		t.Fatalf("Other profiles should have been kept: %+v", cfg.Profiles["other"])
+	}
+	if _, err := os.Stat(filepath.Join(home(), dirName, hooksDir, postCreate)); err != nil {
+		t.Fatalf("Hooks should have been kept: %v", err)
+	}
+}
+
+func TestLogoutRevokesToken(t *testing.T) {
+	startTestEnv(t)
+	defer stopTestEnv(t)
+
+	revoked := ""
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/logout" && r.Method == "POST" {
+			revoked = r.URL.Query().Get("token")
+		}
+	}))
+	defer ts.Close()
+
+	config = &configuration{Server: ts.URL, Token: "1234", logged: true}
+	if err := Logout(false); err != nil {
+		t.Fatalf("Should not given an error: %v", err)
+	}
+	if revoked != "1234" {
+		t.Fatalf("Expected the token '1234' to be revoked; got '%v'", revoked)
+	}
+}
+
+func TestLogoutWithoutEndpoint(t *testing.T) {
+	startTestEnv(t)
+	defer stopTestEnv(t)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.NotFound(w, r)
+	}))
+	defer ts.Close()
+
+	config = &configuration{Server: ts.URL, Token: "1234", logged: true}
+	var err error
+	res := capture.All(func() { err = Logout(false) })
+	if err != nil {
+		t.Fatalf("Should not given an error: %v", err)
+	}
+	if len(res.Stdout) != 0 {
+		t.Fatalf("Not expecting any output; got: %s", res.Stdout)
+	}
+	if LoggedIn() {
+		t.Fatalf("It says that it's logged in when it's not!")
+	}
+}
+
+// pendingChanges fetches the topics from the given test server and then
+// modifies the first one locally without pushing it.
+func pendingChanges(t *testing.T, url string) {
+	config = &configuration{Server: url, Token: "1234", logged: true}
+
+	var err error
+	capture.All(func() { err = fetch() })
+	errCheck(t, err)
+
+	path := filepath.Join(home(), dirName, newDir, "topic1.md")
+	errCheck(t, ioutil.WriteFile(path, []byte("changed"), 0644))
+}
+
+func TestLogoutWithChanges(t *testing.T) {
+	startTestEnv(t)
+	defer stopTestEnv(t)
+
+	ts := topicServer(nil)
+	defer ts.Close()
+	pendingChanges(t, ts.URL)
+
+	oldConfirm := confirm
+	defer func() { confirm = oldConfirm }()
+
+	// The user refuses to push the changes: nothing happens.
+	confirm = func(string) bool { return false }
+	err := Logout(false)
+	if err == nil || !strings.Contains(err.Error(), "you have changes that have not been pushed") {
+		t.Fatalf("Expected an error; got: %v", err)
+	}
+	if _, err = os.Stat(filepath.Join(home(), dirName)); err != nil {
+		t.Fatalf("The cache should not have been removed: %v", err)
+	}
+	if testTopics[0].Contents != "1111" {
+		t.Fatalf("Expected '1111'; got: %v", testTopics[0].Contents)
+	}
+
+	// The user accepts to push the changes first.
+	confirm = func(string) bool { return true }
+	capture.All(func() { err = Logout(false) })
+	if err != nil {
+		t.Fatalf("Should not given an error: %v", err)
+	}
+	if testTopics[0].Contents != "changed" {
+		t.Fatalf("Expected 'changed'; got: %v", testTopics[0].Contents)
+	}
+	if _, err = os.Stat(filepath.Join(home(), dirName, newDir)); !os.IsNotExist(err) {
+		t.Fatalf("The cache should have been removed: %v", err)
+	}
+}
+
+func TestLogoutWithNewFile(t *testing.T) {
+	startTestEnv(t)
+	defer stopTestEnv(t)
+
+	ts := topicServer(nil)
+	defer ts.Close()
+	config = &configuration{Server: ts.URL, Token: "1234", logged: true}
+
+	var err error
+	capture.All(func() { err = fetch() })
+	errCheck(t, err)
+	path := filepath.Join(home(), dirName, newDir, "brandnew.md")
+	errCheck(t, ioutil.WriteFile(path, []byte("new"), 0644))
+
+	oldConfirm := confirm
+	defer func() { confirm = oldConfirm }()
+
+	// The user refuses to push the new file: nothing happens.
+	confirm = func(string) bool { return false }
+	err = Logout(false)
+	if err == nil || !strings.Contains(err.Error(), "you have changes that have not been pushed") {
+		t.Fatalf("Expected an error; got: %v", err)
+	}
+	if _, err = os.Stat(path); err != nil {
+		t.Fatalf("The new file should have been kept: %v", err)
+	}
+
+	// The user accepts: the topic is created before logging out.
+	confirm = func(string) bool { return true }
+	capture.All(func() { err = Logout(false) })
+	if err != nil {
+		t.Fatalf("Should not given an error: %v", err)
+	}
+	if len(testTopics) != 3 || testTopics[2].Name != "brandnew" || testTopics[2].Contents != "new" {
+		t.Fatalf("The topic should have been created: %+v", testTopics)
+	}
+	if LoggedIn() {
+		t.Fatalf("It says that it's logged in when it's not!")
+	}
+}
+
+func TestForcedLogout(t *testing.T) {
+	startTestEnv(t)
+	defer stopTestEnv(t)
+
+	ts := topicServer(nil)
+	defer ts.Close()
+	pendingChanges(t, ts.URL)
+
+	oldConfirm := confirm
+	defer func() { confirm = oldConfirm }()
+	confirm = func(string) bool {
+		t.Fatalf("The user should not be asked anything")
+		return false
+	}
+
+	if err := Logout(true); err != nil {
+		t.Fatalf("Should not given an error: %v", err)
+	}
+	if testTopics[0].Contents != "1111" {
+		t.Fatalf("Expected '1111'; got: %v", testTopics[0].Contents)
+	}
+	if LoggedIn() {
+		t.Fatalf("It says that it's logged in when it's not!")
+	}
+}
+
+func TestLoginProfile(t *testing.T) {
+	startTestEnv(t)
+	defer stopTestEnv(t)
+
+	ts := sessionServer("name", "1234")
+	defer ts.Close()
+
+	config = &configuration{Server: "https://current.org", Token: "current"}
+
+	var err error
+	capture.All(func() { err = LoginProfile("other", ts.URL, "name", "wrong") })
+	if err == nil || !strings.Contains(err.Error(), "wrong credentials") {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	capture.All(func() { err = LoginProfile("other", ts.URL, "name", "1234") })
+	if err != nil {
+		t.Fatalf("Not expecting error: %v", err)
+	}
+
+	// The current session is kept, and the profile is saved.
+	if config.Server != "https://current.org" || config.Token != "current" {
+		t.Fatalf("The current session should not change: %+v", config)
+	}
+	initConfig()
+	if p := config.Profiles["other"]; p == nil || p.Server != ts.URL || p.Token != "1234" {
+		t.Fatalf("Unexpected profile: %+v", p)
+	}
+}
+
+func TestEnvSession(t *testing.T) {
+	startTestEnv(t)
+	defer stopTestEnv(t)
+	defer func() {
+		for _, name := range []string{"TD_SERVER", "TD_TOKEN", "TD_PROFILE"} {
+			errCheck(t, os.Setenv(name, ""))
+		}
+	}()
+
+	// The session from the file.
+	config = &configuration{Server: "http://file", Token: "file"}
+	config.Profiles = map[string]*profile{"ci": {Server: "http://profile", Token: "profile"}}
+	errCheck(t, saveConfig())
+	Initialize()
+	if config.Server != "http://file" || !LoggedIn() {
+		t.Fatalf("Unexpected session: %v", config.Server)
+	}
+	fileData := dataPath()
+
+	// $TD_SERVER alone is ignored.
+	errCheck(t, os.Setenv("TD_SERVER", "http://env"))
+	Initialize()
+	if config.Server != "http://file" || dataPath() != fileData {
+		t.Fatalf("Unexpected session: %v", config.Server)
+	}
+
+	// $TD_TOKEN together with $TD_SERVER.
+	errCheck(t, os.Setenv("TD_TOKEN", "env"))
+	Initialize()
+	if config.Server != "http://env" || config.Token != "env" || !LoggedIn() {
+		t.Fatalf("Unexpected session: %v", config.Server)
+	}
+	if dataPath() == fileData || !strings.HasPrefix(dataPath(), filepath.Join(fileData, envDir)) {
+		t.Fatalf("Unexpected data directory: %v", dataPath())
+	}
+
+	// The session from the file is the one being saved.
+	config.Ignore = []string{"a"}
+	errCheck(t, saveConfig())
+	body, _ := ioutil.ReadFile(configPath(configName))
+	if !strings.Contains(string(body), `"token":"file"`) || strings.Contains(string(body), "http://env") {
+		t.Fatalf("Unexpected configuration: %v", string(body))
+	}
+
+	// Sessions given by the environment cannot log in nor out.
+	if err := Login("http://other", "name", "1234"); err == nil || !strings.Contains(err.Error(), "$TD_TOKEN is set") {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if err := Logout(true); err == nil || !strings.Contains(err.Error(), "$TD_TOKEN is set") {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// $TD_TOKEN without $TD_SERVER.
+	errCheck(t, os.Setenv("TD_SERVER", ""))
+	capture.All(func() { Initialize() })
+	if LoggedIn() {
+		t.Fatalf("It should not be logged in")
+	}
+
+	// $TD_PROFILE.
+	errCheck(t, os.Setenv("TD_TOKEN", ""))
+	errCheck(t, os.Setenv("TD_PROFILE", "ci"))
+	Initialize()
+	if config.Server != "http://profile" || config.Token != "profile" || !LoggedIn() {
+		t.Fatalf("Unexpected session: %v", config.Server)
+	}
+
+	errCheck(t, os.Setenv("TD_PROFILE", "unknown"))
+	res := capture.All(func() { Initialize() })
+	if LoggedIn() || len(res.Stdout) != 0 || !strings.Contains(string(res.Stderr), "no profile named 'unknown'") {
+		t.Fatalf("Unexpected output: %q, %q", res.Stdout, res.Stderr)
 	}
 }
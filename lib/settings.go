@@ -0,0 +1,337 @@
+// Copyright (C) 2014-2017 Miquel Sabaté Solà <mikisabate@gmail.com>
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package lib
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"text/template"
+	"time"
+
+	"github.com/mssola/dym"
+)
+
+// setting is a value from the configuration file that can be managed with the
+// `config` command. The value of a setting is taken from the first of the
+// following places where it's set:
+//
+//  1. A flag (see SetFlag).
+//  2. The TD_<KEY> environment variable (e.g. TD_TIMEOUT).
+//  3. The configuration file.
+//  4. The default value.
+type setting struct {
+	key   string
+	usage string
+	def   string
+
+	// check validates the given value and returns it normalized.
+	check func(string) (string, error)
+
+	field
+}
+
+// field gives access to the value of a setting inside of the configuration.
+// Empty values mean that the setting is not set.
+type field struct {
+	get func(*configuration) string
+	set func(*configuration, string)
+}
+
+// stringField returns the access to the given string field of the
+// configuration.
+func stringField(f func(*configuration) *string) field {
+	return field{
+		get: func(c *configuration) string { return *f(c) },
+		set: func(c *configuration, value string) { *f(c) = value },
+	}
+}
+
+// boolField returns the access to the given boolean field of the
+// configuration, which is a pointer so unset values are not saved.
+func boolField(f func(*configuration) **bool) field {
+	get := func(c *configuration) string {
+		if *f(c) == nil {
+			return ""
+		}
+		return strconv.FormatBool(**f(c))
+	}
+	set := func(c *configuration, value string) {
+		if value == "" {
+			*f(c) = nil
+			return
+		}
+		b, _ := strconv.ParseBool(value)
+		*f(c) = &b
+	}
+	return field{get: get, set: set}
+}
+
+// newSetting returns a setting with the given information.
+func newSetting(key, usage, def string, check func(string) (string, error), f field) *setting {
+	return &setting{key: key, usage: usage, def: def, check: check, field: f}
+}
+
+// wordsValue accepts values that can be parsed as shell words.
+func wordsValue(value string) (string, error) {
+	words, err := shellWords(value)
+	if err == nil && len(words) == 0 {
+		err = errors.New("it cannot be empty")
+	}
+	return value, err
+}
+
+// pathValue accepts paths, which are made absolute.
+func pathValue(value string) (string, error) {
+	if value == "" {
+		return "", errors.New("it cannot be empty")
+	}
+	return filepath.Abs(value)
+}
+
+// boolValue accepts boolean values (e.g. "true", "no", "on" or "0").
+func boolValue(value string) (string, error) {
+	switch strings.ToLower(value) {
+	case "yes", "on":
+		return "true", nil
+	case "no", "off":
+		return "false", nil
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return "", errors.New("it has to be either true or false")
+	}
+	return strconv.FormatBool(b), nil
+}
+
+// durationValue accepts positive durations (e.g. "30s" or "5m").
+func durationValue(value string) (string, error) {
+	d, err := time.ParseDuration(value)
+	if err != nil || d <= 0 {
+		return "", errors.New("it has to be a positive duration (e.g. '30s' or '5m')")
+	}
+	return d.String(), nil
+}
+
+// oneOf returns a function that only accepts the given values.
+func oneOf(values ...string) func(string) (string, error) {
+	return func(value string) (string, error) {
+		if contains(values, value) {
+			return value, nil
+		}
+		return "", fmt.Errorf("it has to be one of: %v", strings.Join(values, ", "))
+	}
+}
+
+// listFormatValue accepts the outputs of the `list` command, or a template
+// for each topic.
+func listFormatValue(value string) (string, error) {
+	if strings.Contains(value, "{{") {
+		_, err := template.New("format").Parse(value)
+		return value, err
+	}
+	return oneOf("tree", "flat", "long", "json")(value)
+}
+
+// settings contains all the settings, sorted by key.
+var settings = []*setting{
+	newSetting("ca_file", "The file with the certificates of the authorities to be trusted.", "",
+		pathValue, stringField(func(c *configuration) *string { return &c.CAFile })),
+	newSetting("cache_ttl", "How long fetched topics are considered fresh (e.g. '5m').", "",
+		durationValue, stringField(func(c *configuration) *string { return &c.CacheTTL })),
+	newSetting("color", "Whether to use colors: 'auto', 'always' or 'never'.", "auto",
+		oneOf("auto", "always", "never"), stringField(func(c *configuration) *string { return &c.Color })),
+	newSetting("editor", "The command to open the editor. Defaults to $VISUAL or $EDITOR.", "",
+		wordsValue, stringField(func(c *configuration) *string { return &c.Editor })),
+	newSetting("file", "The file with commands to be executed when opening the editor (--file).", "",
+		pathValue, stringField(func(c *configuration) *string { return &c.File })),
+	newSetting("file_template", "The arguments of the editor for the file with commands.", "",
+		wordsValue, stringField(func(c *configuration) *string { return &c.FileTemplate })),
+	newSetting("insecure", "Allow the usage of insecure connections (--insecure).", "false",
+		boolValue, boolField(func(c *configuration) **bool { return &c.Insecure })),
+	newSetting("list_format", "The output of 'list': 'tree', 'flat', 'long', 'json' or a template.", "tree",
+		listFormatValue, stringField(func(c *configuration) *string { return &c.ListFormat })),
+	newSetting("timeout", "The timeout for requests to the server.", requestTimeout.String(),
+		durationValue, stringField(func(c *configuration) *string { return &c.Timeout })),
+	newSetting("tls_verify", "Verify the certificate of the server (--tlsverify).", "true",
+		boolValue, boolField(func(c *configuration) **bool { return &c.TLSVerify })),
+}
+
+// SettingKeys returns the keys of all the settings.
+func SettingKeys() []string {
+	var keys []string
+
+	for _, s := range settings {
+		keys = append(keys, s.key)
+	}
+	return keys
+}
+
+// The settings given through flags (see SetFlag).
+var flagSettings = make(map[string]string)
+
+// findSetting returns the setting with the given key.
+func findSetting(key string) (*setting, error) {
+	var keys []string
+
+	for _, s := range settings {
+		if s.key == key {
+			return s, nil
+		}
+		keys = append(keys, s.key)
+	}
+
+	msg := fmt.Sprintf("unknown setting '%v'", key)
+	if similars := dym.Similar(keys, key); len(similars) > 0 {
+		msg += fmt.Sprintf(", maybe you meant '%v'", similars[0])
+	}
+	return nil, See(msg, "config list")
+}
+
+// env returns the environment variable of the setting.
+func (s *setting) env() string {
+	return "TD_" + strings.ToUpper(s.key)
+}
+
+// value returns the value of the setting and where it comes from: "flag",
+// "env", "file" or "default". Malformed values from the environment or from
+// the configuration file are ignored (see checkSettings).
+func (s *setting) value() (string, string) {
+	if value, ok := flagSettings[s.key]; ok {
+		return value, "flag"
+	}
+	if value := os.Getenv(s.env()); value != "" {
+		if v, err := s.check(value); err == nil {
+			return v, "env"
+		}
+	}
+	if config != nil {
+		if value := s.get(config); value != "" {
+			if v, err := s.check(value); err == nil {
+				return v, "file"
+			}
+		}
+	}
+	return s.def, "default"
+}
+
+// checkSettings warns about the malformed values of settings from the
+// environment and from the configuration file, which are ignored.
+func checkSettings() {
+	for _, s := range settings {
+		if value := os.Getenv(s.env()); value != "" {
+			if _, err := s.check(value); err != nil {
+				warning("%v.", fmt.Sprintf("ignoring $%v: %v", s.env(), err))
+			}
+		}
+		if value := s.get(config); value != "" {
+			if _, err := s.check(value); err != nil {
+				warning("%v.", fmt.Sprintf("ignoring the '%v' setting: %v", s.key, err))
+			}
+		}
+	}
+}
+
+// settingValue returns the value of the setting with the given key.
+func settingValue(key string) string {
+	s, err := findSetting(key)
+	if err != nil {
+		panic(err)
+	}
+	value, _ := s.value()
+	return value
+}
+
+// applySettings updates the variables that hold the value of settings.
+func applySettings() {
+	Insecure = settingValue("insecure") == "true"
+	TLSVerify = settingValue("tls_verify") == "true"
+	File = settingValue("file")
+	requestTimeout, _ = time.ParseDuration(settingValue("timeout"))
+}
+
+// SetFlag sets the given setting from a flag, so it takes precedence over the
+// environment and the configuration file.
+func SetFlag(key, value string) error {
+	s, err := findSetting(key)
+	if err != nil {
+		return err
+	}
+	if value, err = s.check(value); err != nil {
+		return NewError(fmt.Sprintf("bad value for '%v': %v", key, err))
+	}
+	flagSettings[key] = value
+	applySettings()
+	return nil
+}
+
+// ConfigGet prints the value of the given setting.
+func ConfigGet(key string) error {
+	s, err := findSetting(key)
+	if err != nil {
+		return err
+	}
+	value, _ := s.value()
+	fmt.Println(value)
+	return nil
+}
+
+// ConfigSet validates the given value and saves it into the configuration
+// file for the given setting.
+func ConfigSet(key, value string) error {
+	s, err := findSetting(key)
+	if err != nil {
+		return err
+	}
+	if value, err = s.check(value); err != nil {
+		return NewError(fmt.Sprintf("bad value for '%v': %v", key, err))
+	}
+	s.set(config, value)
+	if err := saveConfig(); err != nil {
+		return fromError(err)
+	}
+	if env := os.Getenv(s.env()); env != "" {
+		warning("the $%v environment variable takes precedence over this setting.", s.env())
+	}
+	return nil
+}
+
+// ConfigUnset removes the given setting from the configuration file, so its
+// default value is used.
+func ConfigUnset(key string) error {
+	s, err := findSetting(key)
+	if err != nil {
+		return err
+	}
+	s.set(config, "")
+	if err := saveConfig(); err != nil {
+		return fromError(err)
+	}
+	return nil
+}
+
+// ConfigList prints all the settings with their value and where this value
+// comes from.
+func ConfigList() error {
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	_, _ = fmt.Fprintln(w, "KEY\tVALUE\tORIGIN\tDESCRIPTION")
+	for _, s := range settings {
+		value, origin := s.value()
+		if value == "" {
+			value = "-"
+		}
+		_, _ = fmt.Fprintf(w, "%v\t%v\t%v\t%v\n", s.key, value, origin, s.usage)
+	}
+	if err := w.Flush(); err != nil {
+		return fromError(err)
+	}
+	return nil
+}
@@ -0,0 +1,210 @@
+// Copyright (C) 2014-2017 Miquel Sabaté Solà <mikisabate@gmail.com>
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package lib
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mssola/capture"
+)
+
+// resetSettings removes the settings given through flags and the environment.
+func resetSettings() {
+	flagSettings = make(map[string]string)
+	for _, s := range settings {
+		_ = os.Unsetenv(s.env())
+	}
+	applySettings()
+	Insecure = true
+}
+
+func TestSettingPrecedence(t *testing.T) {
+	startTestEnv(t)
+	defer stopTestEnv(t)
+	defer resetSettings()
+
+	s, err := findSetting("timeout")
+	errCheck(t, err)
+	expected := func(value, origin string) {
+		if v, o := s.value(); v != value || o != origin {
+			t.Fatalf("Expected %v from %v, got %v from %v", value, origin, v, o)
+		}
+	}
+
+	expected("15s", "default")
+	config.Timeout = "30s"
+	expected("30s", "file")
+	_ = os.Setenv("TD_TIMEOUT", "1m")
+	expected("1m0s", "env")
+	errCheck(t, SetFlag("timeout", "2m"))
+	expected("2m0s", "flag")
+	if requestTimeout != 2*time.Minute {
+		t.Fatalf("Expected the timeout to be applied, got: %v", requestTimeout)
+	}
+
+	// Malformed values are ignored.
+	flagSettings = make(map[string]string)
+	_ = os.Setenv("TD_TIMEOUT", "soon")
+	expected("30s", "file")
+	config.Timeout = "-5s"
+	expected("15s", "default")
+
+	res := capture.All(func() { checkSettings() })
+	out := string(res.Stdout)
+	if !strings.Contains(out, "ignoring $TD_TIMEOUT") || !strings.Contains(out, "ignoring the 'timeout' setting") {
+		t.Fatalf("Unexpected output: %v", out)
+	}
+}
+
+func TestCheckSettingsOnInitialize(t *testing.T) {
+	startTestEnv(t)
+	defer stopTestEnv(t)
+	defer resetSettings()
+
+	errCheck(t, os.MkdirAll(filepath.Join(home(), dirName), 0755))
+	path := filepath.Join(home(), dirName, configName)
+	errCheck(t, ioutil.WriteFile(path, []byte(`{"timeout":"-5s"}`), 0644))
+	errCheck(t, os.Setenv("TD_TIMEOUT", "lala"))
+	defer func() { _ = os.Unsetenv("TD_TIMEOUT") }()
+
+	// The warnings never get mixed with the output of commands.
+	res := capture.All(func() { Initialize() })
+	if len(res.Stdout) != 0 {
+		t.Fatalf("Not expecting any output; got: %s", res.Stdout)
+	}
+	out := string(res.Stderr)
+	if !strings.Contains(out, "ignoring $TD_TIMEOUT") || !strings.Contains(out, "ignoring the 'timeout' setting") {
+		t.Fatalf("Unexpected output: %v", out)
+	}
+}
+
+func TestSetFlag(t *testing.T) {
+	startTestEnv(t)
+	defer stopTestEnv(t)
+	defer resetSettings()
+
+	errCheck(t, SetFlag("insecure", "false"))
+	errCheck(t, SetFlag("tls_verify", "0"))
+	errCheck(t, SetFlag("file", "script.vim"))
+	abs, _ := filepath.Abs("script.vim")
+	if Insecure || TLSVerify || File != abs {
+		t.Fatalf("Unexpected values: %v %v %v", Insecure, TLSVerify, File)
+	}
+
+	err := SetFlag("insecure", "maybe")
+	if err == nil || !strings.Contains(err.Error(), "bad value for 'insecure'") {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+}
+
+func TestConfigSetGetUnset(t *testing.T) {
+	startTestEnv(t)
+	defer stopTestEnv(t)
+	defer resetSettings()
+
+	errCheck(t, ConfigSet("tls_verify", "no"))
+	errCheck(t, ConfigSet("list_format", "{{.ID}}"))
+	errCheck(t, ConfigSet("editor", "code --wait"))
+
+	var cfg map[string]interface{}
+	body, err := ioutil.ReadFile(filepath.Join(home(), dirName, configName))
+	errCheck(t, err)
+	errCheck(t, json.Unmarshal(body, &cfg))
+	if cfg["tls_verify"] != false || cfg["list_format"] != "{{.ID}}" || cfg["editor"] != "code --wait" {
+		t.Fatalf("Unexpected configuration: %v", cfg)
+	}
+	compareSlices(t, editor(), []string{"code", "--wait"})
+
+	res := capture.All(func() { errCheck(t, ConfigGet("tls_verify")) })
+	if string(res.Stdout) != "false\n" {
+		t.Fatalf("Unexpected output: %v", string(res.Stdout))
+	}
+
+	errCheck(t, ConfigUnset("tls_verify"))
+	res = capture.All(func() { errCheck(t, ConfigGet("tls_verify")) })
+	if string(res.Stdout) != "true\n" || config.TLSVerify != nil {
+		t.Fatalf("Unexpected output: %v", string(res.Stdout))
+	}
+
+	bad := map[string]string{
+		"color":       "blue",
+		"list_format": "table",
+		"cache_ttl":   "forever",
+		"editor":      "'vim",
+	}
+	for key, value := range bad {
+		if err := ConfigSet(key, value); err == nil || !strings.Contains(err.Error(), "bad value") {
+			t.Fatalf("Expected an error for '%v', got: %v", key, err)
+		}
+	}
+}
+
+func TestUnknownSetting(t *testing.T) {
+	err := ConfigGet("colour")
+	if err == nil || !strings.Contains(err.Error(), "unknown setting 'colour', maybe you meant 'color'") {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	err = ConfigSet("whatever", "1")
+	if err == nil || !strings.Contains(err.Error(), "'td config list'") {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+}
+
+func TestConfigList(t *testing.T) {
+	startTestEnv(t)
+	defer stopTestEnv(t)
+	defer resetSettings()
+
+	config.Color = "never"
+	_ = os.Setenv("TD_CACHE_TTL", "5m")
+
+	res := capture.All(func() { errCheck(t, ConfigList()) })
+	lines := strings.Split(strings.TrimSpace(string(res.Stdout)), "\n")
+	if len(lines) != len(settings)+1 {
+		t.Fatalf("Unexpected output: %v", lines)
+	}
+	for _, line := range lines {
+		fields := strings.Fields(line)
+		switch fields[0] {
+		case "cache_ttl":
+			compareSlices(t, fields[1:3], []string{"5m0s", "env"})
+		case "color":
+			compareSlices(t, fields[1:3], []string{"never", "file"})
+		case "editor":
+			compareSlices(t, fields[1:3], []string{"-", "default"})
+		}
+	}
+}
+
+func TestColorSetting(t *testing.T) {
+	startTestEnv(t)
+	defer stopTestEnv(t)
+
+	config.Color = "never"
+	if msg := fromError(errors.New("a")).Error(); msg != "error: a.\n" {
+		t.Fatalf("Unexpected message: %q", msg)
+	}
+	if colorEnabled(true) {
+		t.Fatal("Colors should be disabled")
+	}
+
+	config.Color = "always"
+	if !colorEnabled(false) {
+		t.Fatal("Colors should be enabled")
+	}
+	config.Color = "auto"
+	if colorEnabled(false) || !colorEnabled(true) {
+		t.Fatal("The given value should be used")
+	}
+}
@@ -0,0 +1,369 @@
+// Copyright (C) 2014-2017 Miquel Sabaté Solà <mikisabate@gmail.com>
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package lib
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// The patterns of the files that are ignored when looking for topics in the
+// "new" directory if the user has not configured any. They match the swap and
+// backup files of the most common editors.
+var defaultIgnore = []string{
+	".*",    // Vim swap files, Emacs lock files, our own temporary files...
+	"*~",    // Backup files.
+	"#*#",   // Emacs auto-save files.
+	"*.bak", // More backup files.
+	"*.orig",
+}
+
+// The minimum similarity between the contents of a removed file and the
+// contents of a new file for them to be considered a rename.
+const renameThreshold = 0.5
+
+// ignored returns true if the file with the given name has to be ignored when
+// looking for topics. The patterns are taken from the "ignore" setting of the
+// configuration, or from defaultIgnore if the user has not set it.
+func ignored(name string) bool {
+	patterns := defaultIgnore
+	if config != nil && len(config.Ignore) > 0 {
+		patterns = config.Ignore
+	}
+	for _, p := range patterns {
+		if match, _ := filepath.Match(p, name); match {
+			return true
+		}
+	}
+	return false
+}
+
+// listFiles returns the paths, relative to the given directory, of all the
+// regular files inside of it, recursing into subdirectories. Ignored files
+// and directories are skipped.
+func listFiles(dir string) []string {
+	var files []string
+
+	_ = filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || path == dir {
+			return nil
+		}
+		if ignored(info.Name()) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if info.Mode().IsRegular() {
+			rel, _ := filepath.Rel(dir, path)
+			files = append(files, rel)
+		}
+		return nil
+	})
+	return files
+}
+
+// localTopics returns the names of the topics that have a file inside of the
+// given directory (or inside of its subdirectories for namespaced topics).
+// Files whose name is not properly encoded (e.g. created by the user) are
+// renamed, so they can be found through topicPath afterwards.
+func localTopics(dir string) []string {
+	var names []string
+
+	for _, file := range listFiles(dir) {
+		name, ok := topicName(file)
+		if !ok {
+			continue
+		}
+		if file != topicFile(name) {
+			src, dst := filepath.Join(dir, file), topicPath(dir, name)
+			if _, err := os.Stat(dst); !os.IsNotExist(err) {
+				continue
+			}
+			_ = os.MkdirAll(filepath.Dir(dst), 0755)
+			if err := os.Rename(src, dst); err != nil {
+				continue
+			}
+			pruneDirs(dir, src)
+		}
+		names = append(names, name)
+	}
+	return names
+}
+
+// createdTopics returns the names of the topics that exist in the "new"
+// directory but that are not known yet.
+func createdTopics() []string {
+	var topics []Topic
+	var created []string
+
+	readTopics(&topics)
+	known := make(map[string]bool)
+	for _, t := range topics {
+		known[t.Name] = true
+	}
+	for _, name := range localTopics(dataPath(newDir)) {
+		if !known[name] {
+			created = append(created, name)
+		}
+	}
+	return created
+}
+
+// deletedTopics returns the names of the known topics whose file has been
+// removed from the "new" directory.
+func deletedTopics() []string {
+	var topics []Topic
+	var deleted []string
+
+	readTopics(&topics)
+	present := make(map[string]bool)
+	for _, name := range localTopics(dataPath(newDir)) {
+		present[name] = true
+	}
+	for _, t := range topics {
+		if !present[t.Name] {
+			deleted = append(deleted, t.Name)
+		}
+	}
+	return deleted
+}
+
+// renamePair contains a topic that might have been renamed in the "new"
+// directory.
+type renamePair struct {
+	from, to string
+	score    float64
+}
+
+// similarity returns how similar the two given contents are, from 0 (nothing
+// in common) to 1 (equal). It's computed from the lines that both have in
+// common.
+func similarity(a, b []byte) float64 {
+	if bytes.Equal(a, b) {
+		return 1
+	}
+
+	lines := make(map[string]int)
+	la := strings.Split(string(a), "\n")
+	lb := strings.Split(string(b), "\n")
+	for _, l := range la {
+		lines[l]++
+	}
+	common := 0
+	for _, l := range lb {
+		if lines[l] > 0 {
+			lines[l]--
+			common++
+		}
+	}
+	return float64(2*common) / float64(len(la)+len(lb))
+}
+
+// detectRenames pairs the given removed topics with the given new files by
+// the similarity of their contents. The contents of removed topics are taken
+// from the "old" directory. Empty contents tell nothing about a topic, so they
+// are never paired.
+func detectRenames(deleted, created []string) []renamePair {
+	var pairs, renames []renamePair
+
+	for _, from := range deleted {
+		a, _ := ioutil.ReadFile(topicPath(dataPath(oldDir), from))
+		if len(bytes.TrimSpace(a)) == 0 {
+			continue
+		}
+		for _, to := range created {
+			b, _ := ioutil.ReadFile(topicPath(dataPath(newDir), to))
+			if len(bytes.TrimSpace(b)) == 0 {
+				continue
+			}
+			if score := similarity(a, b); score >= renameThreshold {
+				pairs = append(pairs, renamePair{from: from, to: to, score: score})
+			}
+		}
+	}
+
+	// Pick the most similar pairs first.
+	sort.SliceStable(pairs, func(i, j int) bool { return pairs[i].score > pairs[j].score })
+	used := make(map[string]bool)
+	for _, p := range pairs {
+		if !used[p.from] && !used[p.to] {
+			used[p.from], used[p.to] = true, true
+			renames = append(renames, p)
+		}
+	}
+	return renames
+}
+
+// syncTopics treats the "new" directory as the source of truth and brings the
+// server up to date with it. That is, new files become new topics, removed
+// files become deleted topics (after asking the user), files that have been
+// renamed become renamed topics (also after asking the user) and changed files
+// are pushed. If `only` is not nil, then only the given topics are taken into
+// account: no topics are created nor renamed, and only the given topics can be
+// deleted or pushed. The given conflicted topics still contain conflict
+// markers (see workspace.merge), so they are left alone until they get fixed.
+func syncTopics(only, conflicted []string) error {
+	var errs []string
+	whole := only == nil
+
+	// Removed files that look like new files: the topic has been renamed. Note
+	// that this keeps the ID of the topic and its creation date.
+	var renames []renamePair
+	if whole {
+		renames = detectRenames(deletedTopics(), createdTopics())
+	}
+	// New files from renames that did not happen are only created if the
+	// user says so. Otherwise the topic would end up duplicated. Topics with
+	// conflicts are left alone.
+	skip := make(map[string]bool)
+	for _, name := range conflicted {
+		skip[name] = true
+	}
+	for _, r := range renames {
+		if skip[r.to] {
+			continue
+		}
+		q := fmt.Sprintf("It looks like the topic '%v' has been renamed to '%v'. Rename it?", r.from, r.to)
+		if !confirm(q) {
+			if !confirm(fmt.Sprintf("Create '%v' as a new topic then?", r.to)) {
+				skip[r.to] = true
+				keepTopicFile(r.to)
+			}
+			continue
+		}
+		if err := renameTopic(r.from, r.to); err != nil {
+			errs = append(errs, r.from)
+			skip[r.to] = true
+			keepTopicFile(r.to)
+		}
+	}
+
+	// New files: create the topic and leave an empty file in the "old"
+	// directory, so its contents are pushed as any other change.
+	var created []string
+	if whole {
+		created = createdTopics()
+	}
+	for _, name := range created {
+		if skip[name] {
+			continue
+		}
+		fmt.Printf("Creating the topic '%v'.\n", name)
+		t, err := createTopic(name)
+		if err == nil {
+			err = addCreatedTopic(t)
+		}
+		if err != nil {
+			errs = append(errs, name)
+		} else {
+			postHook(newHookEvent(postCreate, []Topic{*t}))
+		}
+	}
+
+	// Removed files: ask before deleting anything. Otherwise the file is
+	// restored, so it does not get lost in the cache.
+	for _, name := range selectTopics(deletedTopics(), only) {
+		q := fmt.Sprintf("The file of the topic '%v' has been removed. Delete the topic?", name)
+		if confirm(q) {
+			if err := deleteTopic(name); err != nil {
+				errs = append(errs, name)
+			}
+			continue
+		}
+		src := topicPath(dataPath(oldDir), name)
+		dst := topicPath(dataPath(newDir), name)
+		_ = copyFile(src, dst)
+	}
+
+	// Push all the changed files.
+	var changed []Topic
+	for _, t := range changedTopics() {
+		if len(selectTopics([]string{t.Name}, only)) > 0 && !contains(conflicted, t.Name) {
+			changed = append(changed, t)
+		}
+	}
+	if len(changed) > 0 {
+		fmt.Printf("Pushing your changes to the server.\n")
+		pushTopics(changed)
+	}
+
+	if len(errs) > 0 {
+		return NewError("the following topics could not be synchronized: " + strings.Join(errs, ", "))
+	}
+	return nil
+}
+
+// keepFile moves the given file (relative to the given directory) into the
+// "kept" directory, and returns its new path. This is done for files that
+// are not turned into topics: files in the "new" directory would be taken as
+// unpushed changes forever otherwise (see safeFetch), and files from
+// workspaces would be lost.
+func keepFile(dir, file string) (string, error) {
+	src := filepath.Join(dir, file)
+	dst := filepath.Join(dataPath(keptDir), file)
+	if _, err := os.Stat(dst); err == nil {
+		ext := filepath.Ext(dst)
+		dst = strings.TrimSuffix(dst, ext) + time.Now().Format(".20060102150405") + ext
+	}
+
+	_ = os.MkdirAll(filepath.Dir(dst), 0755)
+	if err := os.Rename(src, dst); err != nil {
+		return "", err
+	}
+	pruneDirs(dir, src)
+	return dst, nil
+}
+
+// keepTopicFile moves the file of the given topic out of the "new" directory
+// (see keepFile).
+func keepTopicFile(name string) {
+	dst, err := keepFile(dataPath(newDir), topicFile(name))
+	if err != nil {
+		warning("%v.", fmt.Sprintf("could not move the file of '%v' out of the way: %v", name, err))
+		return
+	}
+	fmt.Printf("The file of '%v' has been moved into '%v'.\n", name, dst)
+}
+
+// selectTopics returns the given names that are also contained in `only`. If
+// `only` is nil, then all the given names are returned.
+func selectTopics(names, only []string) []string {
+	if only == nil {
+		return names
+	}
+
+	var selected []string
+	for _, name := range names {
+		for _, o := range only {
+			if name == o {
+				selected = append(selected, name)
+				break
+			}
+		}
+	}
+	return selected
+}
+
+// addCreatedTopic adds the given topic, which has been created from a file
+// in the "new" directory, into the cache.
+func addCreatedTopic(topic *Topic) error {
+	var topics []Topic
+
+	readTopics(&topics)
+	topics = append(topics, *topic)
+	if err := writeTopics(topics); err != nil {
+		return err
+	}
+	return write(topic, dataPath(oldDir))
+}
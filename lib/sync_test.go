@@ -0,0 +1,275 @@
+// Copyright (C) 2014-2017 Miquel Sabaté Solà <mikisabate@gmail.com>
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package lib
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mssola/capture"
+)
+
+func TestIgnored(t *testing.T) {
+	config = &configuration{}
+
+	for _, name := range []string{".topic.md.swp", ".#topic.md", "topic.md~", "#topic.md#"} {
+		if !ignored(name) {
+			t.Fatalf("'%v' should be ignored", name)
+		}
+	}
+	if ignored("topic.md") {
+		t.Fatalf("'topic.md' should not be ignored")
+	}
+
+	config.Ignore = []string{"draft-*"}
+	if !ignored("draft-topic.md") {
+		t.Fatalf("'draft-topic.md' should be ignored")
+	}
+	if ignored("topic.md~") {
+		t.Fatalf("'topic.md~' should not be ignored")
+	}
+}
+
+func TestSyncTopics(t *testing.T) {
+	startTestEnv(t)
+	defer stopTestEnv(t)
+
+	ts := topicServer(nil)
+	defer ts.Close()
+	config = &configuration{Server: ts.URL, Token: "1234"}
+
+	oldConfirm := confirm
+	defer func() { confirm = oldConfirm }()
+	confirm = func(string) bool { return true }
+
+	oldCommand := editCommand
+	defer func() { editCommand = oldCommand }()
+	editCommand = func(dir string, files []string) error {
+		writeIn(t, dir, "ideas.md", "new ideas")
+		writeIn(t, dir, ".ideas.md.swp", "swap")
+		writeIn(t, dir, ".#topic1.md", "lock")
+		writeIn(t, dir, "topic1.md~", "backup")
+		return os.Remove(filepath.Join(dir, "topic2.md"))
+	}
+
+	var err error
+	capture.All(func() { err = Edit() })
+	if err != nil {
+		t.Fatalf("Not expecting error: %v", err)
+	}
+
+	if len(testTopics) != 2 {
+		t.Fatalf("Expected 2 topics; got %v", len(testTopics))
+	}
+	if testTopics[0].Name != "topic1" || testTopics[0].Contents != "1111" {
+		t.Fatalf("Unexpected topic: %v", testTopics[0])
+	}
+	if testTopics[1].Name != "ideas" || testTopics[1].Contents != "new ideas" {
+		t.Fatalf("Unexpected topic: %v", testTopics[1])
+	}
+
+	var topics []Topic
+	readTopics(&topics)
+	if len(topics) != 2 || topics[1].Name != "ideas" {
+		t.Fatalf("Unexpected local topics: %v", topics)
+	}
+	if len(changedTopics()) != 0 {
+		t.Fatalf("There should be no pending changes")
+	}
+}
+
+func TestSyncRefusedDelete(t *testing.T) {
+	startTestEnv(t)
+	defer stopTestEnv(t)
+
+	ts := topicServer(nil)
+	defer ts.Close()
+	config = &configuration{Server: ts.URL, Token: "1234"}
+
+	oldConfirm := confirm
+	defer func() { confirm = oldConfirm }()
+	confirm = func(string) bool { return false }
+
+	oldCommand := editCommand
+	defer func() { editCommand = oldCommand }()
+	editCommand = func(dir string, files []string) error {
+		return os.Remove(filepath.Join(dir, "topic2.md"))
+	}
+
+	var err error
+	capture.All(func() { err = Edit() })
+	if err != nil {
+		t.Fatalf("Not expecting error: %v", err)
+	}
+	if len(testTopics) != 2 {
+		t.Fatalf("Expected 2 topics; got %v", len(testTopics))
+	}
+
+	// The file has been restored.
+	body, err := ioutil.ReadFile(filepath.Join(home(), dirName, newDir, "topic2.md"))
+	errCheck(t, err)
+	if string(body) != "2222" {
+		t.Fatalf("Expected '2222'; got '%s'", body)
+	}
+}
+
+func TestSimilarity(t *testing.T) {
+	if s := similarity([]byte("a\nb"), []byte("a\nb")); s != 1 {
+		t.Fatalf("Expected 1; got %v", s)
+	}
+	if s := similarity([]byte("a\nb\nc\nd"), []byte("a\nb\nc\nx")); s != 0.75 {
+		t.Fatalf("Expected 0.75; got %v", s)
+	}
+	if s := similarity([]byte("a\nb"), []byte("c\nd")); s != 0 {
+		t.Fatalf("Expected 0; got %v", s)
+	}
+}
+
+func TestSyncRename(t *testing.T) {
+	startTestEnv(t)
+	defer stopTestEnv(t)
+
+	ts := topicServer(nil)
+	defer ts.Close()
+	config = &configuration{Server: ts.URL, Token: "1234"}
+
+	oldConfirm := confirm
+	defer func() { confirm = oldConfirm }()
+	confirm = func(string) bool { return true }
+
+	oldCommand := editCommand
+	defer func() { editCommand = oldCommand }()
+	editCommand = func(dir string, files []string) error {
+		// topic1 is renamed and slightly modified, topic2 is replaced by
+		// something completely different.
+		errCheck(t, os.Rename(filepath.Join(dir, "topic1.md"), filepath.Join(dir, "work.md")))
+		errCheck(t, os.Remove(filepath.Join(dir, "topic2.md")))
+		writeIn(t, dir, "work.md", "1111\nmore")
+		writeIn(t, dir, "home.md", "something else")
+		return nil
+	}
+
+	var err error
+	capture.All(func() { err = Edit() })
+	if err != nil {
+		t.Fatalf("Not expecting error: %v", err)
+	}
+
+	if len(testTopics) != 2 {
+		t.Fatalf("Expected 2 topics; got %v", len(testTopics))
+	}
+	if testTopics[0].ID != "1" || testTopics[0].Name != "work" || testTopics[0].Contents != "1111\nmore" {
+		t.Fatalf("Unexpected topic: %v", testTopics[0])
+	}
+	if testTopics[1].ID != "home" || testTopics[1].Contents != "something else" {
+		t.Fatalf("Unexpected topic: %v", testTopics[1])
+	}
+	if len(changedTopics()) != 0 || len(createdTopics()) != 0 || len(deletedTopics()) != 0 {
+		t.Fatalf("There should be no pending changes")
+	}
+}
+
+func TestSyncNamespaces(t *testing.T) {
+	startTestEnv(t)
+	defer stopTestEnv(t)
+
+	ts := topicServer(nil)
+	defer ts.Close()
+	config = &configuration{Server: ts.URL, Token: "1234"}
+
+	oldCommand := editCommand
+	defer func() { editCommand = oldCommand }()
+	editCommand = func(dir string, files []string) error {
+		errCheck(t, os.MkdirAll(filepath.Join(dir, "work", "q3"), 0755))
+		writeIn(t, filepath.Join(dir, "work", "q3"), "plans.md", "plans")
+		return nil
+	}
+
+	var err error
+	capture.All(func() { err = Edit() })
+	if err != nil {
+		t.Fatalf("Not expecting error: %v", err)
+	}
+	if len(testTopics) != 3 {
+		t.Fatalf("Expected 3 topics; got %v", len(testTopics))
+	}
+	if testTopics[2].Name != "work/q3/plans" || testTopics[2].Contents != "plans" {
+		t.Fatalf("Unexpected topic: %v", testTopics[2])
+	}
+	if len(changedTopics()) != 0 || len(createdTopics()) != 0 {
+		t.Fatalf("There should be no pending changes")
+	}
+}
+
+func TestSyncDeclinedRename(t *testing.T) {
+	for _, create := range []bool{false, true} {
+		startTestEnv(t)
+
+		ts := topicServer(nil)
+		config = &configuration{Server: ts.URL, Token: "1234"}
+
+		oldConfirm := confirm
+		confirm = func(q string) bool {
+			return create && strings.HasPrefix(q, "Create 'work' as a new topic")
+		}
+
+		oldCommand := editCommand
+		editCommand = func(dir string, files []string) error {
+			errCheck(t, os.Rename(filepath.Join(dir, "topic1.md"), filepath.Join(dir, "work.md")))
+			return nil
+		}
+
+		var err error
+		capture.All(func() { err = Edit() })
+		if err != nil {
+			t.Fatalf("Not expecting error: %v", err)
+		}
+
+		// The topic is never deleted, and the new file is only created if the
+		// user says so. Otherwise it's moved out of the way.
+		expected := 2
+		if create {
+			expected = 3
+		}
+		if len(testTopics) != expected || testTopics[0].Name != "topic1" {
+			t.Fatalf("Unexpected topics: %v", testTopics)
+		}
+		kept := filepath.Join(home(), dirName, keptDir, "work.md")
+		if _, err = os.Stat(kept); create != os.IsNotExist(err) {
+			t.Fatalf("Unexpected kept file: %v", err)
+		}
+		if _, err = os.Stat(filepath.Join(home(), dirName, newDir, "work.md")); create == os.IsNotExist(err) {
+			t.Fatalf("Unexpected new file: %v", err)
+		}
+
+		// And the topics can be edited again.
+		editCommand = func(dir string, files []string) error { return nil }
+		capture.All(func() { err = Edit() })
+		confirm, editCommand = oldConfirm, oldCommand
+		ts.Close()
+		if err != nil {
+			t.Fatalf("Not expecting error: %v", err)
+		}
+		stopTestEnv(t)
+	}
+}
+
+func TestDetectRenamesEmpty(t *testing.T) {
+	startTestEnv(t)
+	defer stopTestEnv(t)
+	errCheck(t, initFS())
+
+	writeIn(t, filepath.Join(home(), dirName, oldDir), "topic.md", "")
+	writeIn(t, filepath.Join(home(), dirName, newDir), "empty.md", "\n")
+	writeIn(t, filepath.Join(home(), dirName, newDir), "other.md", "")
+	if renames := detectRenames([]string{"topic"}, []string{"empty", "other"}); len(renames) != 0 {
+		t.Fatalf("Expected no renames; got %v", renames)
+	}
+}
@@ -7,14 +7,14 @@
 package lib
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"os"
-	"os/exec"
 	"path/filepath"
-	"regexp"
 	"strings"
+	"time"
 )
 
 var (
@@ -27,6 +27,10 @@ const (
 	// The name of the list of topics.
 	topicsName = "topics.json"
 
+	// The name of the file containing the last time that the topics were
+	// fetched from the server.
+	fetchedName = "fetched_at"
+
 	// The name for the directory where temporary data gets stored.
 	tmpDir = "tmp"
 
@@ -37,25 +41,31 @@ const (
 	// The name for the directory where the user edits topics (a.k.a. the "To
 	// do" list).
 	newDir = "new"
+
+	// The name for the directory where files that could not be turned into
+	// topics are kept, so the user can recover them.
+	keptDir = "kept"
 )
 
-// Note that all these functions never return an error. This is because all the
-// errors that could be returned are I/O related, and any error of this kind
-// has already been checked because of the initial call to the "Initialize"
-// function in the "main" function. Therefore, it's not worth to check errors,
-// since they will, in fact, never occur.
+// Note that the functions reading from the cache never return an error. This
+// is because all the errors that could be returned are I/O related, and any
+// error of this kind has already been checked because of the initial call to
+// the "Initialize" function in the "main" function. Functions writing into the
+// cache, on the other hand, do return an error: writes are done atomically so
+// a failure (e.g. a full disk) leaves the previous contents untouched, and the
+// caller has to report it.
 
 // Read all the topics that we have localy and put them in the given topics
 // array.
 func readTopics(topics *[]Topic) {
-	file := filepath.Join(home(), dirName, topicsName)
+	file := dataPath(topicsName)
 	body, _ := ioutil.ReadFile(file)
 	_ = json.Unmarshal(body, topics)
 }
 
 // Save the given topics into the list of local topics. Note that this function
 // effectively replaces the previous list.
-func writeTopics(topics []Topic) {
+func writeTopics(topics []Topic) error {
 	// Clean it up, we don't want to store the contents.
 	for k := range topics {
 		topics[k].Contents = ""
@@ -64,54 +74,47 @@ func writeTopics(topics []Topic) {
 	body, _ := json.Marshal(topics)
 
 	// Write the JSON.
-	file := filepath.Join(home(), dirName, topicsName)
-	f, _ := os.Create(file)
-	_, _ = f.Write(body)
-	_ = f.Close()
+	file := dataPath(topicsName)
+	return writeFile(file, body, 0644)
 }
 
 // Add the given topic to the list of local topics.
-func addTopic(topic *Topic) {
+func addTopic(topic *Topic) error {
 	var topics []Topic
 
 	// Add the topic to the JSON file.
 	readTopics(&topics)
 	topics = append(topics, *topic)
-	writeTopics(topics)
+	if err := writeTopics(topics); err != nil {
+		return err
+	}
 
 	// And create the files for this new topic.
-	odir := filepath.Join(home(), dirName, oldDir)
-	write(topic, odir)
-	odir = filepath.Join(home(), dirName, newDir)
-	write(topic, odir)
+	odir := dataPath(oldDir)
+	if err := write(topic, odir); err != nil {
+		return err
+	}
+	odir = dataPath(newDir)
+	return write(topic, odir)
 }
 
 // Returns a list of all the topics that have changed since the last version.
+// That is, the topics whose file in the "new" directory differs from the one
+// in the "old" directory, wherever they are placed in the tree of namespaces.
 func changedTopics() []Topic {
 	var topics, changed []Topic
 	readTopics(&topics)
 
-	re, _ := regexp.Compile(".*/(.+)\\.md")
-	sDir := filepath.Join(home(), dirName, oldDir)
-	dDir := filepath.Join(home(), dirName, newDir)
-	out, _ := exec.Command("diff", "-qr", sDir, dDir).Output()
-
-	for _, l := range strings.Split(string(out), "\n") {
-		// Filter out blank lines.
-		l = strings.TrimSpace(l)
-		if l == "" {
+	sDir := dataPath(oldDir)
+	dDir := dataPath(newDir)
+	for _, v := range topics {
+		current, err := ioutil.ReadFile(topicPath(dDir, v.Name))
+		if err != nil {
 			continue
 		}
-
-		// And now append the topic.
-		match := re.FindSubmatch([]byte(l))
-		if match != nil && len(match) == 2 {
-			name := string(match[1])
-			for _, v := range topics {
-				if v.Name == name {
-					changed = append(changed, v)
-				}
-			}
+		previous, err := ioutil.ReadFile(topicPath(sDir, v.Name))
+		if err != nil || !bytes.Equal(previous, current) {
+			changed = append(changed, v)
 		}
 	}
 	return changed
@@ -121,35 +124,67 @@ func changedTopics() []Topic {
 // will be updates accordingly with the new contents for each file. Plus, this
 // function will also call the "writeTopics" function in order to store the
 // given list of topics into our local list of topics.
-func save(topics []Topic) {
+func save(topics []Topic) error {
 	// First of all, reset the temporary directory.
-	dir := filepath.Join(home(), dirName, tmpDir)
+	dir := dataPath(tmpDir)
 	_ = os.RemoveAll(dir)
 	_ = os.MkdirAll(dir, 0755)
 
 	// Save all the topics to this temporary directory.
 	for _, t := range topics {
-		write(&t, dir)
+		if err := write(&t, dir); err != nil {
+			return err
+		}
 	}
 
 	// Update the old and new directories
-	adir := filepath.Join(home(), dirName, oldDir)
-	_ = copyDir(dir, adir)
-	adir = filepath.Join(home(), dirName, newDir)
-	_ = copyDir(dir, adir)
+	adir := dataPath(oldDir)
+	if err := copyDir(dir, adir); err != nil {
+		return err
+	}
+	adir = dataPath(newDir)
+	if err := copyDir(dir, adir); err != nil {
+		return err
+	}
 
 	// And finally, write the JSON file.
-	writeTopics(topics)
+	if err := writeTopics(topics); err != nil {
+		return err
+	}
+	now := []byte(time.Now().UTC().Format(time.RFC3339))
+	return writeFile(dataPath(fetchedName), now, 0644)
+}
+
+// lastFetched returns the last time that the topics were fetched from the
+// server. It returns the zero time if this is not known.
+func lastFetched() time.Time {
+	body, _ := ioutil.ReadFile(dataPath(fetchedName))
+	t, err := time.Parse(time.RFC3339, strings.TrimSpace(string(body)))
+	if err != nil {
+		return time.Time{}
+	}
+	return t
+}
+
+// freshCache returns true if the topics were fetched within the time set by the
+// "cache_ttl" setting.
+func freshCache() bool {
+	ttl := config.cacheTTL()
+	if ttl == 0 {
+		return false
+	}
+	fetched := lastFetched()
+	return !fetched.IsZero() && time.Since(fetched) < ttl
 }
 
 // Save the contents of the given topic. The file getting created will be the
-// name of the topic with the ".md" extension. The directory where this file
-// will be contained is the given "path" parameter.
-func write(topic *Topic, path string) {
-	path = filepath.Join(path, topic.Name+".md")
-	f, _ := os.Create(path)
-	_, _ = f.WriteString(topic.Contents)
-	_ = f.Close()
+// encoded name of the topic with the ".md" extension (see topicFile). The
+// directory where this file will be contained is the given "path" parameter,
+// which is created if needed.
+func write(topic *Topic, path string) error {
+	path = topicPath(path, topic.Name)
+	_ = os.MkdirAll(filepath.Dir(path), 0755)
+	return writeFile(path, []byte(topic.Contents), 0644)
 }
 
 // Copy all the files from the "new" directory to the "old" directory. This is
@@ -159,13 +194,13 @@ func write(topic *Topic, path string) {
 // slice contains the topics that have already been pushed to the server. The
 // "fails" slice contains the topics that have failed on the push action.
 func update(success, fails []string) {
-	srcDir := filepath.Join(home(), dirName, newDir)
-	dstDir := filepath.Join(home(), dirName, oldDir)
+	srcDir := dataPath(newDir)
+	dstDir := dataPath(oldDir)
 
 	// Copy successes.
 	for _, v := range success {
-		src := filepath.Join(srcDir, v+".md")
-		dst := filepath.Join(dstDir, v+".md")
+		src := topicPath(srcDir, v)
+		dst := topicPath(dstDir, v)
 		_ = copyFile(src, dst)
 	}
 
@@ -175,7 +210,7 @@ func update(success, fails []string) {
 	} else {
 		fmt.Printf("The following topics could not be pushed:\n")
 		for _, v := range fails {
-			fmt.Printf("\t" + v + "\n")
+			fmt.Printf("\t%v\n", v)
 		}
 	}
 }
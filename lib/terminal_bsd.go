@@ -0,0 +1,26 @@
+// Copyright (C) 2014-2017 Miquel Sabaté Solà <mikisabate@gmail.com>
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+//go:build darwin || dragonfly || freebsd || netbsd || openbsd
+// +build darwin dragonfly freebsd netbsd openbsd
+
+package lib
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// isTerminalFile returns true if the given file is a terminal. Note that
+// character devices like /dev/null are not terminals.
+//
+// This function has been adapted from golang.org/x/crypto/ssh/terminal
+func isTerminalFile(f *os.File) bool {
+	var state syscall.Termios
+	_, _, err := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), syscall.TIOCGETA, uintptr(unsafe.Pointer(&state)))
+	return err == 0
+}
@@ -13,7 +13,11 @@ import (
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"os"
+	"path"
 	"path/filepath"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/mssola/dym"
@@ -54,6 +58,102 @@ func unknownTopic(name string) error {
 	return errors.New(msg)
 }
 
+// resolveTopics returns the names of the topics referred by the given
+// arguments. An argument can be the name of a topic, a glob pattern (e.g.
+// "work/*") or a name that is similar enough to the name of a single topic.
+// Arguments can be suffixed with a line number (e.g. "topic:42"). In this
+// case, the returned map contains the line for the referred topics.
+func resolveTopics(args []string) ([]string, map[string]int, error) {
+	var topics []Topic
+	var names, res []string
+	lines := make(map[string]int)
+
+	readTopics(&topics)
+	for _, v := range topics {
+		names = append(names, v.Name)
+	}
+
+	seen := make(map[string]bool)
+	add := func(name string) {
+		if !seen[name] {
+			seen[name] = true
+			res = append(res, name)
+		}
+	}
+
+	for _, arg := range args {
+		if contains(names, arg) {
+			add(arg)
+			continue
+		}
+
+		// Peel off the line number, if any.
+		line := 0
+		if idx := strings.LastIndex(arg, ":"); idx > 0 {
+			if n, err := strconv.Atoi(arg[idx+1:]); err == nil && n > 0 {
+				arg, line = arg[:idx], n
+			}
+		}
+		add := func(name string) {
+			add(name)
+			if line > 0 {
+				lines[name] = line
+			}
+		}
+		if contains(names, arg) {
+			add(arg)
+			continue
+		}
+
+		if strings.ContainsAny(arg, "*?[") {
+			found := false
+			for _, name := range names {
+				if match, _ := path.Match(arg, name); match {
+					add(name)
+					found = true
+				}
+			}
+			if !found {
+				return nil, nil, NewError(fmt.Sprintf("no topic matches '%v'", arg))
+			}
+			continue
+		}
+
+		if name := closestTopic(names, arg); name != "" {
+			progress("Assuming that you meant '%v'.\n", name)
+			add(name)
+			continue
+		}
+		return nil, nil, unknownTopic(arg)
+	}
+	return res, lines, nil
+}
+
+// closestTopic returns the name from the given ones that is the closest to
+// the given word, as long as there are no other names just as close and it is
+// similar enough (see unknownTopic). It returns an empty string otherwise.
+func closestTopic(names []string, word string) string {
+	for dist := 1; dist <= dym.DefaultDistance; dist++ {
+		similars := dym.SimilarDistance(names, word, dist)
+		if len(similars) == 1 {
+			return similars[0]
+		} else if len(similars) > 1 {
+			break
+		}
+	}
+	return ""
+}
+
+// contains returns true if the given slice contains the given string.
+func contains(slice []string, str string) bool {
+	for _, v := range slice {
+		if v == str {
+			return true
+		}
+	}
+	return false
+}
+
 // topicResponse parses the given response and fill the given topic with the
 // abstracted information.
 func topicResponse(t *Topic, res *http.Response) error {
@@ -67,11 +167,174 @@ func topicResponse(t *Topic, res *http.Response) error {
 	return nil
 }
 
+// createTopic creates a topic with the given name on the server and returns
+// it. Note that the topic is not added into the cache.
+func createTopic(name string) (*Topic, error) {
+	if name == "" {
+		return nil, NewError("the name of a topic cannot be empty")
+	}
+
+	// Perform the HTTP request.
+	t := &Topic{Name: name}
+	body, _ := json.Marshal(t)
+	res, err := getResponse("POST", "/topics", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+
+	// Parse the newly created topic.
+	if err = topicResponse(t, res); err != nil {
+		return nil, NewError("could not create this topic: " + err.Error())
+	}
+	return t, nil
+}
+
+// newTopic creates the topic with the given name and contents on the server,
+// adds it to the cache and runs the "post-create" hook.
+func newTopic(name, contents string) (*Topic, error) {
+	t, err := createTopic(name)
+	if err != nil {
+		return nil, err
+	}
+	if contents != "" {
+		if err := pushContents(t.ID, contents); err != nil {
+			return nil, err
+		}
+		t.Contents = contents
+	}
+	if err := addTopic(t); err != nil {
+		return nil, fromError(err)
+	}
+	postHook(newHookEvent(postCreate, []Topic{*t}))
+	return t, nil
+}
+
+// deleteTopic deletes the topic with the given name from the server and from
+// the cache.
+func deleteTopic(name string) error {
+	var topics, actual []Topic
+	var id string
+
+	// Get the list of topics straight.
+	readTopics(&topics)
+	for _, v := range topics {
+		if v.Name == name {
+			id = v.ID
+		} else {
+			actual = append(actual, v)
+		}
+	}
+	if id == "" {
+		return unknownTopic(name)
+	}
+
+	// Perform the HTTP request.
+	if _, err := getResponse("DELETE", "/topics/"+id, nil); err != nil {
+		return err
+	}
+
+	// On the system.
+	if err := writeTopics(actual); err != nil {
+		return fromError(err)
+	}
+	removeTopicFile(dataPath(oldDir), name)
+	removeTopicFile(dataPath(newDir), name)
+	postHook(newHookEvent(postDelete, []Topic{{ID: id, Name: name}}))
+	return nil
+}
+
+// renameTopic renames the given topic both on the server and on the cache. This
+// is done as a single transaction: the cache (the list of topics and the files
+// from the "old" and "new" directories) is updated first and, if the server
+// rejects the rename, every change is rolled back. It refuses new names that
+// collide with existing topics.
+func renameTopic(oldName, newName string) error {
+	var topics []Topic
+	idx := -1
+
+	if newName == "" {
+		return NewError("the name of a topic cannot be empty")
+	}
+	readTopics(&topics)
+	for k, v := range topics {
+		if v.Name == newName {
+			return NewError(fmt.Sprintf("there is already a topic named '%v'", newName))
+		}
+		if v.Name == oldName {
+			idx = k
+		}
+	}
+	if idx < 0 {
+		return unknownTopic(oldName)
+	}
+
+	// Keep track of the changes being done, so they can be undone.
+	var undo []func()
+	rollback := func() {
+		for i := len(undo) - 1; i >= 0; i-- {
+			undo[i]()
+		}
+	}
+
+	// Rename the files. Note that the file might have been renamed already
+	// (e.g. by the user inside of the editor).
+	for _, d := range []string{oldDir, newDir} {
+		dir := dataPath(d)
+		src, dst := topicPath(dir, oldName), topicPath(dir, newName)
+		if _, err := os.Stat(src); os.IsNotExist(err) {
+			continue
+		}
+		if _, err := os.Stat(dst); err == nil {
+			rollback()
+			return NewError(fmt.Sprintf("there is already a file for '%v'", newName))
+		}
+		_ = os.MkdirAll(filepath.Dir(dst), 0755)
+		if err := os.Rename(src, dst); err != nil {
+			rollback()
+			return fromError(err)
+		}
+		pruneDirs(dir, src)
+		undo = append(undo, func() {
+			_ = os.MkdirAll(filepath.Dir(src), 0755)
+			_ = os.Rename(dst, src)
+			pruneDirs(dir, dst)
+		})
+	}
+
+	// Update the list of topics.
+	renamed := make([]Topic, len(topics))
+	copy(renamed, topics)
+	renamed[idx].Name = newName
+	if err := writeTopics(renamed); err != nil {
+		rollback()
+		return fromError(err)
+	}
+	undo = append(undo, func() { _ = writeTopics(topics) })
+
+	// Perform the HTTP Request.
+	t := &Topic{Name: newName}
+	body, _ := json.Marshal(t)
+	res, err := getResponse("PUT", "/topics/"+topics[idx].ID, bytes.NewReader(body))
+	if err != nil {
+		rollback()
+		return err
+	}
+	if err = topicResponse(t, res); err != nil {
+		rollback()
+		return NewError("could not rename this topic: " + err.Error())
+	}
+
+	event := newHookEvent(postRename, []Topic{renamed[idx]})
+	event.OldName = oldName
+	postHook(event)
+	return nil
+}
+
 // safeFetch returns whether it's safe to fetch topics from the server or not.
-// This depends on whether there are changes that have not been pushed or not.
+// This depends on whether there are changes that have not been pushed or not,
+// including files of topics that have not been created on the server yet.
 func safeFetch() bool {
-	topics := changedTopics()
-	return len(topics) == 0
+	return len(changedTopics()) == 0 && len(createdTopics()) == 0
 }
 
 // fetch saves all the topics from the server locally.
@@ -81,49 +344,73 @@ func fetch() error {
 		return errors.New("you have changes on the currently cached topics")
 	}
 
+	topics, err := serverTopics()
+	if err != nil {
+		return err
+	}
+
+	// And save the results.
+	if err := save(topics); err != nil {
+		return fromError(err)
+	}
+	postHook(newHookEvent(postFetch, topics))
+	return nil
+}
+
+// serverTopics fetches all the topics from the server, including their
+// contents.
+func serverTopics() ([]Topic, error) {
 	// Perform the HTTP request.
-	fmt.Printf("Fetching the topics from the server.\n")
+	progress("Fetching the topics from the server.\n")
 	res, err := getResponse("GET", "/topics", nil)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	// Parse the given topics.
 	var topics []Topic
 	body, _ := ioutil.ReadAll(res.Body)
 	if err := json.Unmarshal(body, &topics); err != nil {
-		return fromError(err)
+		return nil, fromError(err)
 	}
+	return topics, nil
+}
 
-	// And save the results.
-	save(topics)
-	return nil
+// pushContents sets the given contents to the topic with the given ID on the
+// server.
+func pushContents(id, contents string) error {
+	body, _ := json.Marshal(&Topic{Contents: contents})
+	_, err := getResponse("PUT", "/topics/"+id, bytes.NewReader(body))
+	return err
 }
 
 // pushTopics pushes all the given topics to the server. Only successful pushes
-// will be updated locally.
-func pushTopics(topics []Topic) {
+// will be updated locally. It returns true if all the topics were pushed
+// successfully.
+func pushTopics(topics []Topic) bool {
 	var success, fails []string
 
+	// The "pre-push" hook can abort the whole push.
+	if err := runHook(newHookEvent(prePush, topics)); err != nil {
+		warning("%v. Nothing has been pushed.", errorMessage(err))
+		return false
+	}
+
 	total := len(topics)
 	for k, v := range topics {
 		// Print the status.
 		fmt.Printf("\rPushing... %v/%v\r", k+1, total)
 
 		// Get the contents.
-		file := filepath.Join(home(), dirName, newDir, v.Name+".md")
+		file := topicPath(dataPath(newDir), v.Name)
 		body, _ := ioutil.ReadFile(file)
-		t := &Topic{Contents: string(body)}
-		if t.Contents == "" {
+		if len(body) == 0 {
 			success = append(success, v.Name)
 			continue
 		}
 
 		// Perform the request.
-		body, _ = json.Marshal(t)
-		path := "/topics/" + v.ID
-		_, err := getResponse("PUT", path, bytes.NewReader(body))
-		if err == nil {
+		if err := pushContents(v.ID, string(body)); err == nil {
 			success = append(success, v.Name)
 		} else {
 			fails = append(fails, v.Name)
@@ -132,4 +419,5 @@ func pushTopics(topics []Topic) {
 
 	// And finally update the file system.
 	update(success, fails)
+	return len(fails) == 0
 }
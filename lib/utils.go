@@ -7,47 +7,62 @@
 package lib
 
 import (
+	"bufio"
 	"crypto/tls"
+	"crypto/x509"
 	"errors"
+	"fmt"
 	"io"
 	"io/ioutil"
 	"net"
 	"net/http"
 	"net/url"
 	"os"
+	"os/exec"
 	"path/filepath"
 	"regexp"
+	"strings"
 	"time"
-)
 
-const (
-	// The fallback editor in case the $EDITOR environment variable is not set.
-	defaultEditor = "vi"
+	"github.com/mssola/colors"
 )
 
 var (
-	// The timeout for any HTTP request.
+	// The timeout for any HTTP request. It's set from the "timeout" setting
+	// (see applySettings).
 	requestTimeout = 15 * time.Second
 
+	// Set to true while the standard output is being used for the data of a
+	// command (e.g. an export), so it's not mixed with other messages.
+	dataOnStdout = false
+
 	// Insecure contains whether HTTP communications are allowed instead of
-	// secure HTTPS ones. Defaults to false.
+	// secure HTTPS ones. Defaults to false. It's set from the "insecure"
+	// setting (see applySettings).
 	Insecure = false
 
 	// TLSVerify sets whether certificates have to be validated. Defaults to
-	// true. Ignored if Insecure is true.
+	// true. Ignored if Insecure is true. It's set from the "tls_verify"
+	// setting (see applySettings).
 	TLSVerify = true
-
-	// File specifies which file the `edit` command should pick in order to
-	// execute commands in the editor during initialization.
-	File = ""
 )
 
+// tdHome returns the value of the $TD environment variable. Plugins get the
+// path of the td executable in $TD instead (see pluginEnv), and the original
+// value in $TD_HOME, so the commands that they run use the same directories.
+func tdHome() string {
+	if info, err := os.Stat(os.Getenv("TD")); err == nil && !info.IsDir() {
+		return os.Getenv("TD_HOME")
+	}
+	return os.Getenv("TD")
+}
+
 // Returns the value of the current home. This value is fetched from the $TD
-// environment variable. If it's not set, then the $HOME environment variable
-// will be picked. If the $HOME environment variable is not set either,
-// then it panics.
+// environment variable (see tdHome). If it's not set, then the $HOME
+// environment variable will be picked. If the $HOME environment variable is
+// not set either, then it panics.
 func home() string {
-	value := os.Getenv("TD")
+	value := tdHome()
 	if value == "" {
 		value = os.Getenv("HOME")
 		if value == "" {
@@ -57,84 +72,253 @@ func home() string {
 	return value
 }
 
-// Returns the value of the $EDITOR environment variable. If this variable is
-// not set, then it will return the value of the "defaultEditor" constant.
-func editor() string {
-	value := os.Getenv("EDITOR")
-	if value == "" {
-		return defaultEditor
+// progress prints the given message about the progress of a command. These
+// messages go to the standard error when the standard output is being used
+// for the data of the command (see dataOnStdout).
+func progress(format string, args ...interface{}) {
+	if dataOnStdout {
+		fmt.Fprintf(os.Stderr, format, args...)
+	} else {
+		fmt.Printf(format, args...)
 	}
-	return value
 }
 
-// Returns the arguments that have to be passed to the editor.
-func editorArguments() []string {
-	if File == "" {
-		return []string{}
+// Done this way to test it. It asks the given question to the user and it
+// returns true if the answer was affirmative. Anything else (including an
+// error while reading the answer) is considered a negative answer. The user
+// is never asked if the standard input is not a terminal.
+var confirm = func(question string) bool {
+	fmt.Printf("%v [y/N] ", question)
+	if !interactive() {
+		fmt.Println("n (the standard input is not a terminal)")
+		return false
+	}
+
+	answer, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil {
+		fmt.Println()
+		return false
+	}
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
+
+// Done this way to test it. It returns true if the standard output is
+// attached to a terminal.
+var isTerminal = func() bool {
+	fi, err := os.Stdout.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+// Done this way to test it. It returns true if the standard input is attached
+// to a terminal, so the user can be asked for things (see isTerminalFile).
+var interactive = func() bool {
+	return isTerminalFile(os.Stdin)
+}
+
+// Interactive returns true if the user can be asked for things, that is, if
+// the standard input is attached to a terminal.
+func Interactive() bool {
+	return interactive()
+}
+
+// colorEnabled returns whether colors have to be used according to the
+// "color" setting. The given value is returned if it's set to "auto".
+func colorEnabled(auto bool) bool {
+	switch settingValue("color") {
+	case "always":
+		return true
+	case "never":
+		return false
 	}
+	return auto
+}
+
+// colored returns the given string with the given color, unless colors are
+// disabled. Messages are always colored when the "color" setting is "auto".
+func colored(c *colors.Color, str string) string {
+	if !colorEnabled(true) {
+		return str
+	}
+	return c.Get(str)
+}
 
-	if editor() != "vim" {
-		if File != "" {
-			warning("the -f/--file flag does not work if it's not Vim.", "")
+// page shows the given text through the pager from the $PAGER environment
+// variable, or through "less" if it's not set. As Git does, "less" is told to
+// quit right away if the text fits in a single screen. If the pager cannot be
+// executed, then the text is printed directly.
+func page(text string) {
+	pager := []string{"less"}
+	if value := strings.TrimSpace(os.Getenv("PAGER")); value != "" {
+		if words, err := shellWords(value); err == nil && len(words) > 0 {
+			pager = words
 		}
-		return []string{}
 	}
 
-	if _, err := os.Stat(File); os.IsNotExist(err) {
-		warning("given file '%s' does not exist.", File)
-		return []string{}
+	cmd := exec.Command(pager[0], pager[1:]...)
+	cmd.Stdin = strings.NewReader(text)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if os.Getenv("LESS") == "" {
+		cmd.Env = append(os.Environ(), "LESS=FRX")
 	}
-	abs, err := filepath.Abs(File)
+	if err := cmd.Run(); err != nil {
+		fmt.Print(text)
+	}
+}
+
+// writeFile writes the given data into the given path atomically. That is,
+// the data is first written into a temporary file in the same directory, it's
+// flushed to disk and then it's renamed into the final path. This way, a crash
+// or a full disk will never leave a partially written file behind.
+func writeFile(path string, data []byte, perm os.FileMode) error {
+	dir := filepath.Dir(path)
+	f, err := ioutil.TempFile(dir, "."+filepath.Base(path)+".")
 	if err != nil {
-		abs = File
+		return err
+	}
+	tmp := f.Name()
+
+	if _, err = f.Write(data); err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Chmod(tmp, perm)
+	}
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		_ = os.Remove(tmp)
+		return err
 	}
-	return []string{"-s", abs}
+	syncDir(dir)
+	return nil
 }
 
-// Copy a file from a source path to a destination path. This function assumes
-// that the source path exists. The only error that can be tolerated is if the
-// user is trying to cpy a file into a protected directory.
+// syncDir flushes the given directory to disk, so renames performed inside of
+// it survive a crash. This is done in a best-effort basis, since some file
+// systems do not support it.
+func syncDir(dir string) {
+	if d, err := os.Open(dir); err == nil {
+		_ = d.Sync()
+		_ = d.Close()
+	}
+}
+
+// Copy a file from a source path to a destination path. The destination file
+// is written atomically (see writeFile), and its directory is created if
+// needed. Any error is returned, in which case the destination is left as it
+// was.
 func copyFile(source string, dest string) error {
-	sf, _ := os.Open(source)
-	defer func() { _ = sf.Close() }()
-	df, err := os.Create(dest)
+	body, err := ioutil.ReadFile(source)
 	if err != nil {
 		return err
 	}
-	defer func() { _ = df.Close() }()
-	_, err = io.Copy(df, sf)
-	return err
+	_ = os.MkdirAll(filepath.Dir(dest), 0755)
+	return writeFile(dest, body, 0644)
+}
+
+// swapName returns the path where the previous version of the given directory
+// is kept while copyDir is swapping it.
+func swapName(dir string) string {
+	return filepath.Join(filepath.Dir(dir), "."+filepath.Base(dir)+".swap")
 }
 
 // Copy a given source directory into a destination directory. If the
-// destination directory already exists, it will be removed. This function will
+// destination directory already exists, it will be replaced. The files are
+// first copied into a temporary directory next to the destination, which is
+// then swapped with the destination. Therefore, a failure in the middle of
+// the process never leaves the destination half-copied. This function will
 // only tolerate the following errors:
 //
-//	1. The copying of the files inside a directory has failed.
+//  1. The copying of the files inside a directory has failed.
 //  2. The source directory cannot be read.
 //
-// Note that subdirectories will *not* be copied. This is because this is a
-// simple function that adjust to our scheme: directories only have regular
-// files inside.
+// Subdirectories (i.e. namespaces of topics) are copied recursively.
 func copyDir(source string, dest string) error {
-	_ = os.RemoveAll(dest)
-	_ = os.MkdirAll(dest, 0755)
-
 	entries, err := ioutil.ReadDir(source)
 	if err != nil {
 		return fromError(err)
 	}
 
+	parent := filepath.Dir(dest)
+	_ = os.MkdirAll(parent, 0755)
+	tmp, err := ioutil.TempDir(parent, "."+filepath.Base(dest)+".")
+	if err != nil {
+		return err
+	}
+	defer func() { _ = os.RemoveAll(tmp) }()
+	_ = os.Chmod(tmp, 0755)
+
+	if err := copyTree(source, tmp, entries); err != nil {
+		return err
+	}
+	return swapDir(tmp, dest)
+}
+
+// copyTree copies the given entries from the source directory into the
+// destination directory, recursing into subdirectories.
+func copyTree(source, dest string, entries []os.FileInfo) error {
 	for _, entry := range entries {
 		sfp := filepath.Join(source, entry.Name())
 		dfp := filepath.Join(dest, entry.Name())
-		if err := copyFile(sfp, dfp); err != nil {
+		if !entry.IsDir() {
+			if err := copyFile(sfp, dfp); err != nil {
+				return err
+			}
+			continue
+		}
+
+		children, err := ioutil.ReadDir(sfp)
+		if err != nil {
+			return err
+		}
+		if err = os.MkdirAll(dfp, 0755); err != nil {
+			return err
+		}
+		if err = copyTree(sfp, dfp, children); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// swapDir replaces the "dest" directory with the "source" one. The previous
+// version of "dest" is kept aside until the swap has been done, so it can be
+// restored by restoreDir if the process is interrupted in between.
+func swapDir(source, dest string) error {
+	backup := swapName(dest)
+	_ = os.RemoveAll(backup)
+
+	if _, err := os.Stat(dest); err == nil {
+		if err := os.Rename(dest, backup); err != nil {
 			return err
 		}
 	}
+	if err := os.Rename(source, dest); err != nil {
+		_ = os.Rename(backup, dest)
+		return err
+	}
+	syncDir(filepath.Dir(dest))
+	_ = os.RemoveAll(backup)
 	return nil
 }
 
+// restoreDir restores the previous version of the given directory if a swap
+// performed by copyDir was interrupted before completion.
+func restoreDir(dir string) {
+	if _, err := os.Stat(dir); !os.IsNotExist(err) {
+		return
+	}
+	if _, err := os.Stat(swapName(dir)); err == nil {
+		_ = os.Rename(swapName(dir), dir)
+	}
+}
+
 // requestURL builds the URL for the given path. The second parameter "token"
 // tells this function whether it should include the authorization token in the
 // query. It returns an error if this library is set to refuse insecure
@@ -165,11 +349,20 @@ func requestURL(path string, token bool) (string, error) {
 // request.
 func safeResponse(method, url string, body io.Reader, token bool) (*http.Response, error) {
 	// Setup the client for the HTTP request.
+	tlsConfig := &tls.Config{InsecureSkipVerify: !TLSVerify || Insecure}
+	if path := settingValue("ca_file"); path != "" {
+		pem, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates could be read from '%v'", path)
+		}
+	}
 	client := http.Client{
-		Timeout: requestTimeout,
-		Transport: &http.Transport{
-			TLSClientConfig: &tls.Config{InsecureSkipVerify: !TLSVerify || Insecure},
-		},
+		Timeout:   requestTimeout,
+		Transport: &http.Transport{TLSClientConfig: tlsConfig},
 	}
 
 	str, err := requestURL(url, token)
@@ -49,74 +49,6 @@ func TestHome(t *testing.T) {
 	home()
 }
 
-func TestEditor(t *testing.T) {
-	errCheck(t, os.Setenv("EDITOR", "emacs"))
-	if e := editor(); e != "emacs" {
-		t.Fatalf("Expected 'emacs', Got '%v'", e)
-	}
-
-	errCheck(t, os.Setenv("EDITOR", ""))
-	if e := editor(); e != defaultEditor {
-		t.Fatalf("Expected '%v', Got '%v'", defaultEditor, e)
-	}
-}
-
-func TestEditorArguments(t *testing.T) {
-	defer func() { File = "" }()
-
-	File = ""
-	args := editorArguments()
-	if len(args) != 0 {
-		t.Fatalf("Expecting just 1 argument, got %v", len(args))
-	}
-
-	errCheck(t, os.Setenv("EDITOR", "emacs"))
-	args = editorArguments()
-	if len(args) != 0 {
-		t.Fatalf("Expecting just 1 argument, got %v", len(args))
-	}
-
-	File = "something"
-	res := capture.All(func() { args = editorArguments() })
-	if len(args) != 0 {
-		t.Fatalf("Expecting just 1 argument, got %v", len(args))
-	}
-	str := "the -f/--file flag does not work if it's not Vim"
-	if !strings.Contains(string(res.Stdout), str) {
-		t.Fatalf("Expecting '%s'; got: %s", str, res.Stdout)
-	}
-
-	errCheck(t, os.Setenv("EDITOR", "vim"))
-	res = capture.All(func() { args = editorArguments() })
-	if len(args) != 0 {
-		t.Fatalf("Expecting just 1 argument, got %v", len(args))
-	}
-	str = "given file 'something' does not exist"
-	if !strings.Contains(string(res.Stdout), str) {
-		t.Fatalf("Expecting '%s'; got: %s", str, res.Stdout)
-	}
-
-	p, err := os.Getwd()
-	if err != nil {
-		t.Fatalf("could not get working directory")
-	}
-	if strings.HasSuffix(p, "lib") {
-		p = filepath.Dir(p)
-	}
-	File = filepath.Join(p, "README.md")
-
-	args = editorArguments()
-	if len(args) != 2 {
-		t.Fatalf("Expecting 2 argument, got %v", len(args))
-	}
-	if args[0] != "-s" {
-		t.Fatalf("Expecting '-s' argument, got %v", args[0])
-	}
-	if args[1] != File {
-		t.Fatalf("Expecting '%v', argument, got %v", File, args[0])
-	}
-}
-
 func TestCopyFile(t *testing.T) {
 	startTestEnv(t)
 	defer stopTestEnv(t)
@@ -168,10 +100,12 @@ func TestCopyFile(t *testing.T) {
 	}
 
 	// One does not simply walk into Mordor...
+	// The files are copied into a temporary directory with a random suffix
+	// first, which cannot be created there.
 	err = copyDir("/tmp/td/good", "/tmp/td/mordor/good")
-	msg := "open /tmp/td/mordor/good/test.txt: permission denied"
-	if err.Error() != msg {
-		t.Fatalf("Expected %v; got %v", err.Error(), msg)
+	prefix, suffix := "mkdir /tmp/td/mordor/.good.", ": permission denied"
+	if err == nil || !strings.HasPrefix(err.Error(), prefix) || !strings.HasSuffix(err.Error(), suffix) {
+		t.Fatalf("Expected %v*%v; got %v", prefix, suffix, err)
 	}
 
 	// ... but you can replace Mordor :D
@@ -188,6 +122,60 @@ func TestCopyFile(t *testing.T) {
 	}
 }
 
+func TestWriteFile(t *testing.T) {
+	errCheck(t, os.RemoveAll("/tmp/td"))
+	errCheck(t, os.MkdirAll("/tmp/td", 0755))
+
+	errCheck(t, writeFile("/tmp/td/file.md", []byte("first"), 0644))
+	errCheck(t, writeFile("/tmp/td/file.md", []byte("second"), 0644))
+	body, err := ioutil.ReadFile("/tmp/td/file.md")
+	errCheck(t, err)
+	if string(body) != "second" {
+		t.Fatalf("Expected 'second'; got '%s'", body)
+	}
+
+	// Failures leave the previous contents untouched.
+	if err = writeFile("/tmp/td/missing/file.md", []byte("third"), 0644); err == nil {
+		t.Fatalf("We actually expected an error to happen here")
+	}
+
+	// And no temporary files are left behind.
+	entries, err := ioutil.ReadDir("/tmp/td")
+	errCheck(t, err)
+	if len(entries) != 1 || entries[0].Name() != "file.md" {
+		t.Fatalf("Expected only 'file.md'; got %v entries", len(entries))
+	}
+}
+
+func TestRestoreDir(t *testing.T) {
+	errCheck(t, os.RemoveAll("/tmp/td"))
+	errCheck(t, os.MkdirAll("/tmp/td", 0755))
+
+	// Simulate a copyDir that has been interrupted right in the middle of the
+	// swap: the destination has been moved aside but not replaced.
+	errCheck(t, os.MkdirAll(swapName("/tmp/td/old"), 0755))
+	errCheck(t, ioutil.WriteFile(filepath.Join(swapName("/tmp/td/old"), "a.md"), []byte("a"), 0644))
+
+	restoreDir("/tmp/td/old")
+	body, err := ioutil.ReadFile("/tmp/td/old/a.md")
+	errCheck(t, err)
+	if string(body) != "a" {
+		t.Fatalf("Expected 'a'; got '%s'", body)
+	}
+	if _, err = os.Stat(swapName("/tmp/td/old")); !os.IsNotExist(err) {
+		t.Fatalf("The swap directory should be gone: %v", err)
+	}
+
+	// A complete copy leaves no swap directory behind.
+	errCheck(t, copyDir("/tmp/td/old", "/tmp/td/new"))
+	errCheck(t, copyDir("/tmp/td/old", "/tmp/td/new"))
+	entries, err := ioutil.ReadDir("/tmp/td")
+	errCheck(t, err)
+	if len(entries) != 2 {
+		t.Fatalf("Expected 2 entries; got %v", len(entries))
+	}
+}
+
 func TestRequestURL(t *testing.T) {
 	Insecure = false
 	config = &configuration{
@@ -319,3 +307,36 @@ func TestErrorOnGetResponse(t *testing.T) {
 		t.Fatalf("Expected '%v'; got: %v", msg, err)
 	}
 }
+
+func TestPage(t *testing.T) {
+	oldPager := os.Getenv("PAGER")
+	defer func() { _ = os.Setenv("PAGER", oldPager) }()
+
+	errCheck(t, os.Setenv("PAGER", "tr a-z A-Z"))
+	res := capture.All(func() { page("some text\n") })
+	if string(res.Stdout) != "SOME TEXT\n" {
+		t.Fatalf("Unexpected output: %q", res.Stdout)
+	}
+
+	// Falls back to printing the text directly.
+	errCheck(t, os.Setenv("PAGER", "/nonexistent/pager"))
+	res = capture.All(func() { page("some text\n") })
+	if string(res.Stdout) != "some text\n" {
+		t.Fatalf("Unexpected output: %q", res.Stdout)
+	}
+}
+
+func TestConfirmWithoutTerminal(t *testing.T) {
+	oldInteractive := interactive
+	defer func() { interactive = oldInteractive }()
+	interactive = func() bool { return false }
+
+	var answer bool
+	res := capture.All(func() { answer = confirm("Delete everything?") })
+	if answer {
+		t.Fatalf("Expected a negative answer")
+	}
+	if !strings.Contains(string(res.Stdout), "the standard input is not a terminal") {
+		t.Fatalf("Unexpected output: %s", res.Stdout)
+	}
+}
@@ -0,0 +1,256 @@
+// Copyright (C) 2014-2017 Miquel Sabaté Solà <mikisabate@gmail.com>
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package lib
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// The name of the directory containing the workspaces of the editor
+	// sessions.
+	sessionsDir = "sessions"
+
+	// The name of the directory inside of a workspace containing the
+	// contents of the "new" directory when the session started.
+	baseDir = "base"
+
+	// The name of the directory inside of a workspace where the user edits
+	// the topics.
+	workDir = "work"
+)
+
+// workspace is the directory where a single editor session takes place. This
+// way, concurrent sessions never see the half-edited files of each other.
+type workspace struct {
+	path string
+
+	// The files (relative to the workspace) being edited on this session. If
+	// it's nil, then the whole workspace is being edited.
+	files []string
+
+	// The line where the cursor has to be placed for some of the files.
+	lines map[string]int
+}
+
+// newWorkspace creates a new workspace from the current contents of the "new"
+// directory. The given names of topics are the ones to be edited on this
+// session; if nil, then all of them are. It assumes that the caller holds the
+// lock of the cache.
+func newWorkspace(topics []string) (*workspace, error) {
+	dir := dataPath(sessionsDir)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	path, err := ioutil.TempDir(dir, fmt.Sprintf("%v-", os.Getpid()))
+	if err != nil {
+		return nil, err
+	}
+
+	ws := &workspace{path: path, lines: make(map[string]int)}
+	for _, name := range topics {
+		ws.files = append(ws.files, topicFile(name))
+	}
+	src := dataPath(newDir)
+	if err = copyDir(src, ws.base()); err == nil {
+		err = copyDir(src, ws.work())
+	}
+	if err != nil {
+		ws.remove()
+		return nil, err
+	}
+	return ws, nil
+}
+
+// base returns the path to the snapshot of the "new" directory taken when the
+// session started.
+func (ws *workspace) base() string {
+	return filepath.Join(ws.path, baseDir)
+}
+
+// work returns the path to the directory where the editor has to be opened.
+func (ws *workspace) work() string {
+	return filepath.Join(ws.path, workDir)
+}
+
+// editable returns the given files (relative to the workspace) that can be
+// edited on this session.
+func (ws *workspace) editable(files []string) []string {
+	if ws.files == nil {
+		return files
+	}
+
+	var res []string
+	for _, f := range files {
+		for _, e := range ws.files {
+			if f == e {
+				res = append(res, f)
+				break
+			}
+		}
+	}
+	return res
+}
+
+// remove deletes the workspace from the file system.
+func (ws *workspace) remove() {
+	_ = os.RemoveAll(ws.path)
+}
+
+// merge brings the changes done in this workspace back into the "new"
+// directory. If a file has also been changed in "new" since the session
+// started (i.e. by another session), both versions are merged and, if that's
+// not possible, conflict markers are left in the file. Only the files being
+// edited on this session are merged, other changed files are moved into the
+// "kept" directory (see keepFile). It returns the names of the files with
+// conflicts. It assumes that the caller holds the lock of the cache.
+func (ws *workspace) merge() ([]string, error) {
+	var conflicts []string
+
+	if _, err := os.Stat(ws.work()); err != nil {
+		return nil, err
+	}
+
+	dst := dataPath(newDir)
+	for _, name := range ws.editable(listFiles(ws.work())) {
+		mine, _ := ioutil.ReadFile(filepath.Join(ws.work(), name))
+		base, baseErr := ioutil.ReadFile(filepath.Join(ws.base(), name))
+		if baseErr == nil && bytes.Equal(mine, base) {
+			// Not touched on this session.
+			continue
+		}
+
+		// Merge it if the file in "new" has changed since the session started.
+		theirs, theirsErr := ioutil.ReadFile(filepath.Join(dst, name))
+		untouched := theirsErr != nil || (baseErr == nil && bytes.Equal(theirs, base))
+		if !untouched && !bytes.Equal(theirs, mine) {
+			var clean bool
+			mine, clean = merge3(mine, base, theirs)
+			if !clean {
+				conflicts = append(conflicts, name)
+			}
+		}
+		_ = os.MkdirAll(filepath.Dir(filepath.Join(dst, name)), 0755)
+		if err := writeFile(filepath.Join(dst, name), mine, 0644); err != nil {
+			return conflicts, err
+		}
+	}
+
+	// Files that are not being edited on this session (e.g. created by the
+	// user while editing some topics) cannot be merged, but they are kept.
+	var kept []string
+	for _, name := range listFiles(ws.work()) {
+		if len(ws.editable([]string{name})) > 0 {
+			continue
+		}
+		mine, _ := ioutil.ReadFile(filepath.Join(ws.work(), name))
+		base, err := ioutil.ReadFile(filepath.Join(ws.base(), name))
+		if err == nil && bytes.Equal(mine, base) {
+			continue
+		}
+		dst, err := keepFile(ws.work(), name)
+		if err != nil {
+			return conflicts, err
+		}
+		kept = append(kept, dst)
+	}
+	if len(kept) > 0 {
+		warning("the following files are not part of the topics being edited, so "+
+			"they have been moved out of the way:%v", "\n\t"+strings.Join(kept, "\n\t"))
+	}
+
+	// Files removed on this session are removed from "new" too, unless they
+	// have been changed by another session in the meantime.
+	for _, name := range ws.editable(listFiles(ws.base())) {
+		if _, err := os.Stat(filepath.Join(ws.work(), name)); !os.IsNotExist(err) {
+			continue
+		}
+		base, _ := ioutil.ReadFile(filepath.Join(ws.base(), name))
+		theirs, err := ioutil.ReadFile(filepath.Join(dst, name))
+		if err == nil && bytes.Equal(theirs, base) {
+			_ = os.Remove(filepath.Join(dst, name))
+			pruneDirs(dst, filepath.Join(dst, name))
+		}
+	}
+	return conflicts, nil
+}
+
+// merge3 performs a three-way merge between the given contents. It returns
+// the merged contents and whether the merge was clean or not. When the merge
+// is not clean, conflict markers are left in the returned contents.
+func merge3(mine, base, theirs []byte) ([]byte, bool) {
+	dir, err := ioutil.TempDir(dataPath(sessionsDir), "merge-")
+	if err == nil {
+		defer func() { _ = os.RemoveAll(dir) }()
+
+		files := []string{"mine", "base", "theirs"}
+		for k, contents := range [][]byte{mine, base, theirs} {
+			files[k] = filepath.Join(dir, files[k])
+			_ = ioutil.WriteFile(files[k], contents, 0644)
+		}
+
+		// The diff3 command exits with 0 on clean merges, with 1 on merges
+		// with conflicts and with 2 on trouble.
+		args := []string{"-m", "-L", "this session", "-L", "original", "-L", "another session"}
+		out, err := exec.Command("diff3", append(args, files...)...).Output()
+		if err == nil {
+			return out, true
+		}
+		if e, ok := err.(*exec.ExitError); ok && e.ExitCode() == 1 {
+			return out, false
+		}
+	}
+
+	// We could not use diff3, just keep both versions.
+	var buffer bytes.Buffer
+	buffer.WriteString(mineMarker + "\n")
+	buffer.Write(withNewline(mine))
+	buffer.WriteString("=======\n")
+	buffer.Write(withNewline(theirs))
+	buffer.WriteString(theirsMarker + "\n")
+	return buffer.Bytes(), false
+}
+
+// The labels of the conflict markers left by merge3.
+const (
+	mineMarker   = "<<<<<<< this session"
+	theirsMarker = ">>>>>>> another session"
+)
+
+// conflictedTopics returns the names of the given topics whose file in the
+// "new" directory still contains the conflict markers left by merge3.
+func conflictedTopics(topics []Topic) []string {
+	var names []string
+
+	for _, t := range topics {
+		contents, err := ioutil.ReadFile(topicPath(dataPath(newDir), t.Name))
+		if err != nil {
+			continue
+		}
+		// Markers are not always on a line of their own, since diff3 does not
+		// add the missing new line at the end of the files.
+		if bytes.Contains(contents, []byte(mineMarker)) && bytes.Contains(contents, []byte(theirsMarker)) {
+			names = append(names, t.Name)
+		}
+	}
+	return names
+}
+
+// withNewline returns the given contents making sure that they end with a
+// new line character.
+func withNewline(contents []byte) []byte {
+	if len(contents) > 0 && contents[len(contents)-1] != '\n' {
+		return append(contents, '\n')
+	}
+	return contents
+}
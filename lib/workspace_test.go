@@ -0,0 +1,244 @@
+// Copyright (C) 2014-2017 Miquel Sabaté Solà <mikisabate@gmail.com>
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package lib
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mssola/capture"
+)
+
+func readNew(t *testing.T, name string) string {
+	body, err := ioutil.ReadFile(filepath.Join(home(), dirName, newDir, name))
+	errCheck(t, err)
+	return string(body)
+}
+
+func writeIn(t *testing.T, dir, name, contents string) {
+	errCheck(t, ioutil.WriteFile(filepath.Join(dir, name), []byte(contents), 0644))
+}
+
+func TestWorkspaceMerge(t *testing.T) {
+	startTestEnv(t)
+	defer stopTestEnv(t)
+
+	errCheck(t, save([]Topic{
+		{ID: "1", Name: "topic1", Contents: "a\nb\nc\n"},
+		{ID: "2", Name: "topic2", Contents: "2222"},
+		{ID: "3", Name: "topic3", Contents: "3333"},
+	}))
+
+	ws, err := newWorkspace(nil)
+	errCheck(t, err)
+	other, err := newWorkspace(nil)
+	errCheck(t, err)
+
+	// Both sessions change different parts of topic1, only one session
+	// changes topic2 and both sessions change topic3 differently.
+	writeIn(t, ws.work(), "topic1.md", "A\nb\nc\n")
+	writeIn(t, ws.work(), "topic3.md", "mine")
+	writeIn(t, other.work(), "topic1.md", "a\nb\nC\n")
+	writeIn(t, other.work(), "topic2.md", "other")
+	writeIn(t, other.work(), "topic3.md", "theirs")
+
+	conflicts, err := other.merge()
+	errCheck(t, err)
+	other.remove()
+	if len(conflicts) != 0 {
+		t.Fatalf("Expected no conflicts; got %v", conflicts)
+	}
+
+	conflicts, err = ws.merge()
+	errCheck(t, err)
+	ws.remove()
+	compareSlices(t, conflicts, []string{"topic3.md"})
+
+	if c := readNew(t, "topic1.md"); c != "A\nb\nC\n" {
+		t.Fatalf("Unexpected merge: %q", c)
+	}
+	if c := readNew(t, "topic2.md"); c != "other" {
+		t.Fatalf("The changes of the other session were clobbered: %q", c)
+	}
+	c := readNew(t, "topic3.md")
+	if !strings.Contains(c, "mine") || !strings.Contains(c, "theirs") {
+		t.Fatalf("Both versions should have been kept: %q", c)
+	}
+
+	// Workspaces are gone.
+	entries, _ := ioutil.ReadDir(filepath.Join(home(), dirName, sessionsDir))
+	if len(entries) != 0 {
+		t.Fatalf("Expected no workspaces; got %v", len(entries))
+	}
+}
+
+func TestEditWorkspace(t *testing.T) {
+	startTestEnv(t)
+	defer stopTestEnv(t)
+
+	ts := topicServer(nil)
+	defer ts.Close()
+	config = &configuration{Server: ts.URL, Token: "1234"}
+
+	oldCommand := editCommand
+	defer func() { editCommand = oldCommand }()
+	editCommand = func(dir string, files []string) error {
+		// The editor is not opened in the shared directory.
+		if dir == filepath.Join(home(), dirName, newDir) {
+			t.Fatalf("The editor has been opened in the shared directory")
+		}
+		// And other processes can use the cache meanwhile.
+		unlock, err := lockCache()
+		errCheck(t, err)
+		unlock()
+		return ioutil.WriteFile(filepath.Join(dir, "topic2.md"), []byte("edited"), 0644)
+	}
+
+	var err error
+	capture.All(func() { err = Edit() })
+	if err != nil {
+		t.Fatalf("Not expecting error: %v", err)
+	}
+	if testTopics[1].Contents != "edited" {
+		t.Fatalf("Expecting \"edited\"; got: %v", testTopics[1].Contents)
+	}
+	if _, err = os.Stat(lockPath()); !os.IsNotExist(err) {
+		t.Fatalf("The lock should have been released: %v", err)
+	}
+}
+
+func TestEditWithConflicts(t *testing.T) {
+	startTestEnv(t)
+	defer stopTestEnv(t)
+
+	ts := topicServer(nil)
+	defer ts.Close()
+	config = &configuration{Server: ts.URL, Token: "1234"}
+
+	oldCommand := editCommand
+	defer func() { editCommand = oldCommand }()
+	editCommand = func(dir string, files []string) error {
+		// Another session changes topic1 meanwhile.
+		errCheck(t, ioutil.WriteFile(filepath.Join(home(), dirName, newDir, "topic1.md"), []byte("theirs"), 0644))
+		errCheck(t, ioutil.WriteFile(filepath.Join(dir, "topic1.md"), []byte("mine"), 0644))
+		return ioutil.WriteFile(filepath.Join(dir, "topic2.md"), []byte("edited"), 0644)
+	}
+
+	var err error
+	res := capture.All(func() { err = Edit() })
+	if err != nil {
+		t.Fatalf("Not expecting error: %v", err)
+	}
+	if !strings.Contains(string(res.Stdout), "they have not been pushed") {
+		t.Fatalf("Unexpected output: %s", res.Stdout)
+	}
+
+	// The topic with conflict markers is not pushed, but the rest is.
+	if testTopics[0].Contents != "1111" {
+		t.Fatalf("The conflicted topic should not have been pushed: %q", testTopics[0].Contents)
+	}
+	if testTopics[1].Contents != "edited" {
+		t.Fatalf("Expecting \"edited\"; got: %v", testTopics[1].Contents)
+	}
+	if c := readNew(t, "topic1.md"); !strings.Contains(c, "mine") || !strings.Contains(c, "theirs") {
+		t.Fatalf("Both versions should have been kept: %q", c)
+	}
+}
+
+func TestEditAfterConflicts(t *testing.T) {
+	startTestEnv(t)
+	defer stopTestEnv(t)
+
+	ts := topicServer(nil)
+	defer ts.Close()
+	config = &configuration{Server: ts.URL, Token: "1234"}
+
+	oldCommand := editCommand
+	defer func() { editCommand = oldCommand }()
+	editCommand = func(dir string, files []string) error {
+		errCheck(t, ioutil.WriteFile(filepath.Join(home(), dirName, newDir, "topic1.md"), []byte("theirs"), 0644))
+		return ioutil.WriteFile(filepath.Join(dir, "topic1.md"), []byte("mine"), 0644)
+	}
+	var err error
+	capture.All(func() { err = Edit() })
+	errCheck(t, err)
+
+	// The conflicts are not fixed on the next edit: nothing fails and the
+	// topic is still not pushed.
+	editCommand = func(dir string, files []string) error {
+		return ioutil.WriteFile(filepath.Join(dir, "topic2.md"), []byte("edited"), 0644)
+	}
+	res := capture.All(func() { err = Edit() })
+	if err != nil {
+		t.Fatalf("Not expecting error: %v", err)
+	}
+	if !strings.Contains(string(res.Stdout), "still have conflict markers") {
+		t.Fatalf("Unexpected output: %s", res.Stdout)
+	}
+	if testTopics[0].Contents != "1111" {
+		t.Fatalf("The conflicted topic should not have been pushed: %q", testTopics[0].Contents)
+	}
+	if testTopics[1].Contents != "edited" {
+		t.Fatalf("Expecting \"edited\"; got: %v", testTopics[1].Contents)
+	}
+
+	// And once they are fixed, the topic is pushed.
+	editCommand = func(dir string, files []string) error {
+		return ioutil.WriteFile(filepath.Join(dir, "topic1.md"), []byte("fixed"), 0644)
+	}
+	capture.All(func() { err = Edit() })
+	if err != nil {
+		t.Fatalf("Not expecting error: %v", err)
+	}
+	if testTopics[0].Contents != "fixed" {
+		t.Fatalf("Expecting \"fixed\"; got: %v", testTopics[0].Contents)
+	}
+}
+
+func TestEditKeepsOtherFiles(t *testing.T) {
+	startTestEnv(t)
+	defer stopTestEnv(t)
+
+	ts := topicServer(nil)
+	defer ts.Close()
+	config = &configuration{Server: ts.URL, Token: "1234"}
+
+	oldCommand := editCommand
+	defer func() { editCommand = oldCommand }()
+	editCommand = func(dir string, files []string) error {
+		writeIn(t, dir, "topic1.md", "edited")
+		writeIn(t, dir, "topic2.md", "not being edited")
+		return ioutil.WriteFile(filepath.Join(dir, "brandnew.md"), []byte("new"), 0644)
+	}
+
+	var err error
+	res := capture.All(func() { err = Edit("topic1") })
+	if err != nil {
+		t.Fatalf("Not expecting error: %v", err)
+	}
+	if !strings.Contains(string(res.Stdout), "moved out of the way") {
+		t.Fatalf("Unexpected output: %s", res.Stdout)
+	}
+	if testTopics[0].Contents != "edited" || testTopics[1].Contents != "2222" || len(testTopics) != 2 {
+		t.Fatalf("Unexpected topics: %v", testTopics)
+	}
+
+	// The other files are not lost.
+	for name, contents := range map[string]string{"brandnew.md": "new", "topic2.md": "not being edited"} {
+		body, err := ioutil.ReadFile(filepath.Join(home(), dirName, keptDir, name))
+		errCheck(t, err)
+		if string(body) != contents {
+			t.Fatalf("Expected %q; got %q", contents, body)
+		}
+	}
+	if c := readNew(t, "topic2.md"); c != "2222" {
+		t.Fatalf("Unexpected contents for topic2: %q", c)
+	}
+}
@@ -8,7 +8,11 @@ package main
 
 import (
 	"fmt"
+	"io"
+	"io/ioutil"
 	"os"
+	"strconv"
+	"strings"
 	"unicode"
 	"unicode/utf8"
 
@@ -38,12 +42,17 @@ func errAndExit(err error) {
 // flagOrPrompt tries to fetch the value for the requested flag from the given
 // CLI context. If that is not possible, then it prompts the user asking for
 // the information. If the `secure` parameter is set to true, then the password
-// won't be shown.
+// won't be shown. The user is never prompted if the standard input is not a
+// terminal.
 func flagOrPrompt(ctx *cli.Context, name string, secure bool) (string, error) {
 	// If the flag already provides the value, just return it.
 	if val := ctx.String(name); val != "" {
 		return val, nil
 	}
+	if !lib.Interactive() {
+		msg := fmt.Sprintf("missing the --%v flag (the standard input is not a terminal)", name)
+		return "", lib.NewError(msg)
+	}
 
 	// Show the prompt by uppercasing the first letter of the given name.
 	r, n := utf8.DecodeRuneInString(name)
@@ -67,6 +76,9 @@ func flagOrPrompt(ctx *cli.Context, name string, secure bool) (string, error) {
 // fetch details needed for the `lib.Login` function. If a flag is not passed,
 // then the user will be prompted to give the information manually.
 func readLoginDetails(ctx *cli.Context) (string, string, string, error) {
+	if err := checkPasswordFlags(ctx); err != nil {
+		return "", "", "", err
+	}
 	server, err := flagOrPrompt(ctx, "server", false)
 	if err != nil {
 		return "", "", "", err
@@ -75,13 +87,68 @@ func readLoginDetails(ctx *cli.Context) (string, string, string, error) {
 	if err != nil {
 		return "", "", "", err
 	}
-	password, err := flagOrPrompt(ctx, "password", true)
+	password, err := loginPassword(ctx)
 	if err != nil {
 		return "", "", "", err
 	}
 	return server, username, password, nil
 }
 
+// checkPasswordFlags returns an error if the `login` command has been given
+// the password in more than one way.
+func checkPasswordFlags(ctx *cli.Context) error {
+	given := 0
+	for _, set := range []bool{ctx.String("password") != "", ctx.Bool("password-stdin"), ctx.String("password-file") != ""} {
+		if set {
+			given++
+		}
+	}
+	if given > 1 {
+		return lib.NewError("only one of --password, --password-stdin and --password-file can be given")
+	}
+	return nil
+}
+
+// loginPassword returns the password given to the `login` command, which can
+// be read from the standard input (--password-stdin) or from a file
+// (--password-file), so it doesn't show up in the list of processes. The
+// trailing newline is removed. Otherwise it behaves like flagOrPrompt.
+func loginPassword(ctx *cli.Context) (string, error) {
+	var body []byte
+	var err error
+	switch {
+	case ctx.Bool("password-stdin"):
+		body, err = ioutil.ReadAll(os.Stdin)
+	case ctx.String("password-file") != "":
+		body, err = ioutil.ReadFile(ctx.String("password-file"))
+	default:
+		return flagOrPrompt(ctx, "password", true)
+	}
+	if err != nil {
+		return "", lib.NewError("could not read the password: " + err.Error())
+	}
+	return strings.TrimRight(string(body), "\r\n"), nil
+}
+
+// helpPrinter wraps the given function for printing the help, so the help of
+// the given application also lists the available plugins. Note that commands
+// with subcommands have an application of their own, which is left alone.
+func helpPrinter(app *cli.App, printHelp func(io.Writer, string, interface{})) func(io.Writer, string, interface{}) {
+	return func(w io.Writer, templ string, data interface{}) {
+		printHelp(w, templ, data)
+		if data != app || templ != cli.AppHelpTemplate {
+			return
+		}
+		if plugins := lib.Plugins(); len(plugins) > 0 {
+			fmt.Fprintln(w, "PLUGINS:")
+			for _, p := range plugins {
+				fmt.Fprintf(w, "    %v\t%v\n", p.Name, p.Path)
+			}
+			fmt.Fprintln(w)
+		}
+	}
+}
+
 // loggedCommand wraps the given function by making sure that the current user
 // is logged in. If this is not the case, it shows an error message and exits.
 func loggedCommand(f func(*cli.Context)) func(*cli.Context) {
@@ -106,6 +173,12 @@ func require(ctx *cli.Context, expected int) {
 }
 
 func main() {
+	// Shell completion asks for the names of topics on each key stroke, so
+	// they are served straight from the cache (see the `completion` command).
+	if len(os.Args) == 2 && os.Args[1] == lib.TopicsCommand {
+		errAndExit(lib.List(&lib.ListOptions{Flat: true, Offline: true}))
+	}
+
 	lib.Initialize()
 
 	app := cli.NewApp()
@@ -113,16 +186,124 @@ func main() {
 	app.Usage = "A CLI tool for a 'todo' server."
 	app.Version = version()
 
+	// Unknown commands might be provided by plugins (see lib.Plugins). On
+	// `td help <plugin>`, the plugin is asked for its help instead. Note that
+	// this function is also called for the unknown subcommands of commands
+	// like `config`, which have their own app: plugins are only looked up for
+	// top-level commands.
 	app.CommandNotFound = func(context *cli.Context, cmd string) {
+		if path, ok := lib.FindPlugin(cmd); ok && context.App == app {
+			args := context.Args().Tail()
+			if context.Command.Name == "help" {
+				args = []string{"--help"}
+			}
+			status, err := lib.RunPlugin(path, args)
+			if err != nil {
+				fmt.Print(err)
+			}
+			os.Exit(status)
+		}
 		fmt.Printf("Incorrect usage: command '%v' does not exist.\n\n", cmd)
 		cli.ShowAppHelp(context)
+		os.Exit(1)
 	}
 
-	app.Action = loggedCommand(func(ctx *cli.Context) {
-		errAndExit(lib.Edit())
-	})
+	app.Action = func(ctx *cli.Context) {
+		if ctx.Args().Present() {
+			app.CommandNotFound(ctx, ctx.Args().First())
+		}
+		loggedCommand(func(ctx *cli.Context) {
+			errAndExit(lib.Edit())
+		})(ctx)
+	}
+	cli.HelpPrinter = helpPrinter(app, cli.HelpPrinter)
 
 	app.Commands = []cli.Command{
+		{
+			Name:  "edit",
+			Usage: "Edit the given topics, or all of them if none is given.",
+			ArgsUsage: `[<topic>...]
+
+Where <topic> is the name of a topic, a glob pattern (e.g. 'work/*') or a name
+that is close enough to the name of an existing topic. Only the given topics
+are opened in the editor and pushed afterwards.`,
+			Action: loggedCommand(func(ctx *cli.Context) {
+				errAndExit(lib.Edit(ctx.Args()...))
+			}),
+		},
+		{
+			Name:  "backup",
+			Usage: "Save the local state and the topics from the server into an archive.",
+			ArgsUsage: `<path>
+
+Where <path> is the archive to be written. It's compressed if it ends with '.gz'
+or '.tgz'.`,
+			Action: loggedCommand(func(ctx *cli.Context) {
+				require(ctx, 1)
+				errAndExit(lib.Backup(ctx.Args()[0], ctx.Bool("without-token")))
+			}),
+			Flags: []cli.Flag{
+				cli.BoolFlag{
+					Name:  "without-token",
+					Usage: "Do not save the tokens from the configuration.",
+				},
+			},
+		},
+		{
+			Name:  "completion",
+			Usage: "Print the completion script for the given shell.",
+			ArgsUsage: `<shell>
+
+Where <shell> is either bash, zsh or fish. For example, for bash:
+
+    $ source <(td completion bash)`,
+			Action: func(ctx *cli.Context) {
+				require(ctx, 1)
+				errAndExit(lib.Completion(ctx.Args().First(), ctx.App.Name,
+					completedCommands(ctx.App), completedFlags(ctx.App.Flags)))
+			},
+		},
+		{
+			Name:  "config",
+			Usage: "Manage the settings from the configuration file.",
+			Subcommands: []cli.Command{
+				{
+					Name:      "get",
+					Usage:     "Print the value of a setting.",
+					ArgsUsage: "<key>",
+					Action: func(ctx *cli.Context) {
+						require(ctx, 1)
+						errAndExit(lib.ConfigGet(ctx.Args().First()))
+					},
+				},
+				{
+					Name:      "set",
+					Usage:     "Save the value of a setting into the configuration file.",
+					ArgsUsage: "<key> <value>",
+					Action: func(ctx *cli.Context) {
+						require(ctx, 2)
+						errAndExit(lib.ConfigSet(ctx.Args()[0], ctx.Args()[1]))
+					},
+				},
+				{
+					Name:      "unset",
+					Usage:     "Remove a setting from the configuration file.",
+					ArgsUsage: "<key>",
+					Action: func(ctx *cli.Context) {
+						require(ctx, 1)
+						errAndExit(lib.ConfigUnset(ctx.Args().First()))
+					},
+				},
+				{
+					Name:      "list",
+					Usage:     "List all the settings with their value and where it comes from.",
+					ArgsUsage: " ",
+					Action: func(ctx *cli.Context) {
+						errAndExit(lib.ConfigList())
+					},
+				},
+			},
+		},
 		{
 			Name:  "create",
 			Usage: "Create a new topic.",
@@ -145,18 +326,131 @@ Where <topic> is the name of the topic to be deleted.`,
 				errAndExit(lib.Delete(ctx.Args()[0]))
 			}),
 		},
+		{
+			Name:  "export",
+			Usage: "Export all the topics.",
+			ArgsUsage: `[<path>]
+
+Where <path> is the file or the directory where the topics will be exported. The
+'json' and 'tar' formats are written into the standard output if no path is
+given (or if it's '-'). Archives with the '.gz' or '.tgz' extension are
+compressed. For the 'html' format, <path> is the file to be written if
+--bundle is given.`,
+			Action: loggedCommand(func(ctx *cli.Context) {
+				if len(ctx.Args()) > 1 {
+					require(ctx, 1)
+				}
+				errAndExit(lib.Export(ctx.Args().First(), &lib.ExportOptions{
+					Format:  ctx.String("format"),
+					Sort:    ctx.String("sort"),
+					Bundle:  ctx.Bool("bundle"),
+					Builtin: ctx.Bool("builtin"),
+				}))
+			}),
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "format",
+					Value: "html",
+					Usage: "The format of the export: 'json', 'dir', 'tar' or 'html'.",
+				},
+				cli.StringFlag{
+					Name:  "sort",
+					Value: "name",
+					Usage: "Sort the topics by 'name' or by 'created' date.",
+				},
+				cli.BoolFlag{
+					Name:  "bundle",
+					Usage: "Write all the topics into a single HTML file.",
+				},
+				cli.BoolFlag{
+					Name:  "builtin",
+					Usage: "Always use the builtin markdown renderer instead of the HTML from the server.",
+				},
+			},
+		},
+		{
+			Name:  "import",
+			Usage: "Import topics exported with the export command.",
+			ArgsUsage: `<path>
+
+Where <path> is the JSON file, the directory or the tar archive containing the
+topics to be imported. Use '-' to read JSON or tar data from the standard input.`,
+			Action: loggedCommand(func(ctx *cli.Context) {
+				require(ctx, 1)
+				errAndExit(lib.Import(ctx.Args()[0], &lib.ImportOptions{
+					Format:       ctx.String("format"),
+					DryRun:       ctx.Bool("dry-run"),
+					SkipExisting: ctx.Bool("skip-existing"),
+					Overwrite:    ctx.Bool("overwrite"),
+				}))
+			}),
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "format",
+					Usage: "The format of the data: 'json', 'dir' or 'tar'. Guessed from the path by default.",
+				},
+				cli.BoolFlag{
+					Name:  "dry-run",
+					Usage: "Show what would be imported without changing anything.",
+				},
+				cli.BoolFlag{
+					Name:  "skip-existing",
+					Usage: "Skip the topics that already exist.",
+				},
+				cli.BoolFlag{
+					Name:  "overwrite",
+					Usage: "Replace the contents of the topics that already exist.",
+				},
+			},
+		},
 		{
 			Name:      "list",
 			Usage:     "List the available topics.",
 			ArgsUsage: " ",
-			Action:    loggedCommand(func(ctx *cli.Context) { errAndExit(lib.List()) }),
+			Action: loggedCommand(func(ctx *cli.Context) {
+				errAndExit(lib.List(&lib.ListOptions{
+					Flat:    ctx.Bool("flat"),
+					JSON:    ctx.Bool("json"),
+					Long:    ctx.Bool("long"),
+					Format:  ctx.String("format"),
+					Sort:    ctx.String("sort"),
+					Offline: ctx.Bool("offline"),
+				}))
+			}),
+			Flags: []cli.Flag{
+				cli.BoolFlag{
+					Name:  "flat",
+					Usage: "Show the full name of each topic instead of a tree of namespaces.",
+				},
+				cli.BoolFlag{
+					Name:  "json",
+					Usage: "Show the topics as JSON.",
+				},
+				cli.BoolFlag{
+					Name:  "long, l",
+					Usage: "Show the ID, creation date, size and local state of each topic.",
+				},
+				cli.StringFlag{
+					Name:  "format",
+					Usage: "Show each topic with the given Go template (e.g. '{{.ID}} {{.Name}}').",
+				},
+				cli.StringFlag{
+					Name:  "sort",
+					Usage: "Sort the topics by 'name', 'created' date or 'size'.",
+				},
+				cli.BoolFlag{
+					Name:  "offline",
+					Usage: "Show the cached topics without contacting the server.",
+				},
+			},
 		},
 		{
 			Name:      "login",
 			Usage:     "Log the current user.",
 			ArgsUsage: " ",
 			Action: func(ctx *cli.Context) {
-				if lib.LoggedIn() {
+				profile := ctx.String("profile")
+				if lib.LoggedIn() && profile == "" {
 					fmt.Println("You are already logged in. Doing nothing...")
 					os.Exit(0)
 				}
@@ -168,6 +462,9 @@ Where <topic> is the name of the topic to be deleted.`,
 				if server == "" || name == "" || password == "" {
 					errAndExit(lib.NewError("missing information"))
 				}
+				if profile != "" {
+					errAndExit(lib.LoginProfile(profile, server, name, password))
+				}
 				errAndExit(lib.Login(server, name, password))
 			},
 			Flags: []cli.Flag{
@@ -183,13 +480,95 @@ Where <topic> is the name of the topic to be deleted.`,
 					Name:  "p, password",
 					Usage: "Password.",
 				},
+				cli.BoolFlag{
+					Name:  "password-stdin",
+					Usage: "Read the password from the standard input.",
+				},
+				cli.StringFlag{
+					Name:  "password-file",
+					Usage: "Read the password from the given file.",
+				},
+				cli.StringFlag{
+					Name:  "profile",
+					Usage: "Save the credentials into a profile with this name instead of the current session.",
+				},
 			},
 		},
 		{
 			Name:      "logout",
 			Usage:     "Delete the current session.",
 			ArgsUsage: " ",
-			Action:    loggedCommand(func(ctx *cli.Context) { errAndExit(lib.Logout()) }),
+			Action: loggedCommand(func(ctx *cli.Context) {
+				errAndExit(lib.Logout(ctx.Bool("force")))
+			}),
+			Flags: []cli.Flag{
+				cli.BoolFlag{
+					Name:  "force",
+					Usage: "Log out even if there are changes that have not been pushed.",
+				},
+			},
+		},
+		{
+			Name:  "show",
+			Usage: "Show the contents of the given topics.",
+			ArgsUsage: `<topic>...
+
+Where <topic> is the name of a topic, a glob pattern (e.g. 'work/*') or a name
+that is close enough to the name of an existing topic.`,
+			Action: loggedCommand(func(ctx *cli.Context) {
+				if len(ctx.Args()) == 0 {
+					require(ctx, 1)
+				}
+				errAndExit(lib.Show(ctx.Args()...))
+			}),
+		},
+		{
+			Name:      "migrate",
+			Usage:     "Copy all the topics from one server to another.",
+			ArgsUsage: " ",
+			Action: func(ctx *cli.Context) {
+				if ctx.String("to") == "" {
+					errAndExit(lib.NewError("the destination has to be given with --to"))
+				}
+				errAndExit(lib.Migrate(ctx.String("from"), ctx.String("to"), ctx.String("state")))
+			},
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "from",
+					Value: "current",
+					Usage: "The profile or the URL of the server to copy the topics from.",
+				},
+				cli.StringFlag{
+					Name:  "to",
+					Usage: "The profile or the URL of the server to copy the topics to.",
+				},
+				cli.StringFlag{
+					Name:  "state",
+					Usage: "The file where the state of the migration and the mapping of IDs are saved.",
+				},
+			},
+		},
+		{
+			Name:  "restore",
+			Usage: "Restore the local state from a backup.",
+			ArgsUsage: `<archive>
+
+Where <archive> is an archive written by the backup command, or '-' to read it
+from the standard input.`,
+			Action: func(ctx *cli.Context) {
+				require(ctx, 1)
+				errAndExit(lib.Restore(ctx.Args()[0], ctx.Bool("push"), ctx.Bool("force")))
+			},
+			Flags: []cli.Flag{
+				cli.BoolFlag{
+					Name:  "push",
+					Usage: "Push the topics from the backup back to the server.",
+				},
+				cli.BoolFlag{
+					Name:  "force",
+					Usage: "Restore the backup even if there are changes that have not been pushed.",
+				},
+			},
 		},
 		{
 			Name:  "rename",
@@ -206,22 +585,43 @@ Where <name> is the old name and <new-name> the one to be used from now on.`,
 
 	app.Flags = []cli.Flag{
 		cli.BoolFlag{
-			Name:        "insecure",
-			Usage:       "Allow the usage of insecure connections.",
-			Destination: &lib.Insecure,
+			Name:  "insecure",
+			Usage: "Allow the usage of insecure connections.",
 		},
 		cli.BoolTFlag{
-			Name:        "tlsverify",
-			Usage:       "Verify the remote server. Ignored if --insecure is set to true.",
-			Destination: &lib.TLSVerify,
+			Name:  "tlsverify",
+			Usage: "Verify the remote server. Ignored if --insecure is set to true.",
 		},
 		cli.StringFlag{
 			Name: "file, f",
 			Usage: "Specify a file containing commands to be executed when opening the editor. " +
-				"This only applies to the edit command and Vim",
-			Destination: &lib.File,
+				"This is supported for Vim, Neovim and Emacs, and for other editors through " +
+				"the 'file_template' setting",
 		},
 	}
 
+	// Global flags override the settings from the environment and from the
+	// configuration file, but only if they were given.
+	app.Before = func(ctx *cli.Context) error {
+		settings := map[string]string{
+			"insecure":  strconv.FormatBool(ctx.GlobalBool("insecure")),
+			"tlsverify": strconv.FormatBool(ctx.GlobalBool("tlsverify")),
+			"file":      ctx.GlobalString("file"),
+		}
+		for flag, value := range settings {
+			if !ctx.GlobalIsSet(flag) {
+				continue
+			}
+			key := flag
+			if flag == "tlsverify" {
+				key = "tls_verify"
+			}
+			if err := lib.SetFlag(key, value); err != nil {
+				errAndExit(err)
+			}
+		}
+		return nil
+	}
+
 	app.RunAndExitOnError()
 }